@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Formats of the access log.
+const (
+	logCommon = "common" // Common Log Format
+	logJSON   = "json"   // a JSON object per line
+	logOff    = "off"
+)
+
+type requestIDKey struct{}
+
+// requestID returns the ID of the request, set by the observe middleware.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns the ID the client sent in the X-Request-ID header,
+// if it is reasonable, or a random one.
+func newRequestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= 128 {
+		printable := true
+		for i := 0; i < len(id); i++ {
+			if id[i] < 0x21 || id[i] > 0x7e {
+				printable = false
+				break
+			}
+		}
+		if printable {
+			return id
+		}
+	}
+
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Print(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// recorder records the status and the size of a response.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses working.
+func (r *recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// accessLog writes a line per request, in one of the log formats.
+type accessLog struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format string
+}
+
+// logEntry is a line of the JSON access log.
+type logEntry struct {
+	Time       string  `json:"time"`
+	ID         string  `json:"id"`
+	RemoteAddr string  `json:"remoteAddr"`
+	Method     string  `json:"method"`
+	URI        string  `json:"uri"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	Duration   float64 `json:"duration"` // seconds
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"userAgent,omitempty"`
+}
+
+func (l *accessLog) write(r *http.Request, rec *recorder, start time.Time) {
+	host := clientIP(r)
+
+	var err error
+	var line []byte
+	switch l.format {
+	case logCommon:
+		size := "-"
+		if rec.bytes > 0 {
+			size = strconv.FormatInt(rec.bytes, 10)
+		}
+		line = []byte(fmt.Sprintf("%s - - [%s] %q %d %s\n",
+			host, start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+loggedURI(r)+" "+r.Proto, rec.status, size))
+	case logJSON:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		err = enc.Encode(logEntry{
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			ID:         requestID(r),
+			RemoteAddr: host,
+			Method:     r.Method,
+			URI:        loggedURI(r),
+			Proto:      r.Proto,
+			Status:     rec.status,
+			Bytes:      rec.bytes,
+			Duration:   time.Since(start).Seconds(),
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		})
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		line = buf.Bytes()
+	default:
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.out.Write(line); err != nil {
+		log.Print(err)
+	}
+}
+
+// loggedURI returns the URI of the request without API key secrets.
+func loggedURI(r *http.Request) string {
+	q := r.URL.Query()
+	if !q.Has("api_key") {
+		return r.RequestURI
+	}
+	q.Set("api_key", "REDACTED")
+	u := *r.URL
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// observe returns a handler that gives every request an ID, logs it to
+// the access log, and counts it in the metrics.
+func observe(h http.Handler, accessLog *accessLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := newRequestID(r)
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+		rec := &recorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		code := strconv.Itoa(rec.status)
+		httpRequests.add(code, 1)
+		httpResponseBytes.add(code, float64(rec.bytes))
+		accessLog.write(r, rec, start)
+	})
+}
@@ -0,0 +1,150 @@
+// Keeps the preview, the permalink and the browser URL in sync with the
+// controls of the playground.
+(function () {
+	"use strict";
+
+	var controls = document.getElementById("controls");
+	var image = document.getElementById("image");
+	var error = document.getElementById("error");
+	var permalink = document.getElementById("permalink");
+	var direct = document.getElementById("direct");
+	var endpoint = document.getElementById("endpoint");
+	var layers = document.getElementById("layers");
+	var inputs = controls.querySelectorAll("[name]");
+	var timer;
+	var renders = 0; // requests for previews, to ignore the stale ones
+
+	function value(input) {
+		return input.type === "checkbox" ? String(input.checked) : input.value;
+	}
+
+	// query returns the forms of the controls; all of them, or only the
+	// ones that differ from their default values.
+	function query(all) {
+		var params = new URLSearchParams();
+		inputs.forEach(function (input) {
+			if (all || value(input) !== input.dataset.default) {
+				params.set(input.name, value(input));
+			}
+		});
+		new URLSearchParams(layers.value).forEach(function (v, k) {
+			params.set(k, v);
+		});
+		return params.toString();
+	}
+
+	function update() {
+		var sep = endpoint.value.indexOf("?") === -1 ? "?" : "&";
+		var src = endpoint.value + sep + query(true);
+		var link = "/playground?" + query(false);
+
+		image.classList.add("loading");
+		direct.href = src;
+		permalink.href = link;
+		history.replaceState(null, "", link);
+		render(src);
+	}
+
+	// render fetches the preview once, and shows either the image or the
+	// detail of the problem the server answered with.  Only the latest
+	// request is shown.
+	function render(src) {
+		var seq = ++renders;
+		fetch(src, {
+			headers: {"Accept": "image/*, application/problem+json"}
+		}).then(function (resp) {
+			if (resp.ok) {
+				return resp.blob().then(function (blob) {
+					return {blob: blob};
+				});
+			}
+			return resp.json().then(function (problem) {
+				return {error: problem.detail || problem.title};
+			}, function () {
+				return {error: resp.status + " " + resp.statusText};
+			});
+		}).catch(function (err) {
+			return {error: err.message};
+		}).then(function (result) {
+			if (seq !== renders) {
+				return;
+			}
+			image.classList.remove("loading");
+			if (result.error !== undefined) {
+				error.textContent = result.error;
+				error.hidden = false;
+				return;
+			}
+			error.hidden = true;
+			if (image.src.indexOf("blob:") === 0) {
+				URL.revokeObjectURL(image.src);
+			}
+			image.src = URL.createObjectURL(result.blob);
+		});
+	}
+
+	function scheduleUpdate() {
+		clearTimeout(timer);
+		timer = setTimeout(update, 300);
+	}
+
+	image.addEventListener("load", function () {
+		image.classList.remove("loading");
+	});
+
+	// the preview of the page itself failed to load, fetch it to show why
+	image.addEventListener("error", function () {
+		if (image.src.indexOf("blob:") !== 0) {
+			image.classList.remove("loading");
+			render(image.src);
+		}
+	});
+
+	controls.addEventListener("input", function (event) {
+		var target = event.target;
+		if (target.dataset.for) {
+			document.getElementById(target.dataset.for).value = target.value;
+		} else {
+			var slider = controls.querySelector("[data-for='" + target.id + "']");
+			if (slider) {
+				slider.value = target.value;
+			}
+		}
+		scheduleUpdate();
+	});
+
+	controls.addEventListener("change", scheduleUpdate);
+	endpoint.addEventListener("change", update);
+
+	controls.addEventListener("submit", function (event) {
+		event.preventDefault();
+		update();
+	});
+
+	// presets first reset every control to its default value
+	document.querySelectorAll("#presets button").forEach(function (button) {
+		button.addEventListener("click", function () {
+			var params = new URLSearchParams(button.dataset.query);
+			var rest = new URLSearchParams();
+			inputs.forEach(function (input) {
+				var v = params.has(input.name) ?
+					params.get(input.name) : input.dataset.default;
+				params.delete(input.name);
+				if (input.type === "checkbox") {
+					input.checked = v === "true";
+				} else {
+					input.value = v;
+				}
+				var slider = controls.querySelector("[data-for='" + input.id + "']");
+				if (slider) {
+					slider.value = v;
+				}
+			});
+			params.forEach(function (v, k) {
+				rest.set(k, v);
+			});
+			layers.value = rest.toString();
+			update();
+		});
+	});
+}());
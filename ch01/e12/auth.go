@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+// apiKey is a key of the keys file.  Its secret is only known by its
+// SHA-256 hash.  Zero limits are the ones of the server.
+type apiKey struct {
+	Name         string  `json:"name"`
+	Hash         string  `json:"hash"` // hex encoded
+	Admin        bool    `json:"admin,omitempty"`
+	DailyRenders int     `json:"dailyRenders,omitempty"` // 0 for no quota
+	MaxPixels    int     `json:"maxPixels,omitempty"`
+	MaxFrames    int     `json:"maxFrames,omitempty"`
+	MaxSamples   float64 `json:"maxSamples,omitempty"`
+	MaxMemory    float64 `json:"maxMemory,omitempty"` // pixels of all the frames
+	Rate         float64 `json:"rate,omitempty"`
+	Burst        int     `json:"burst,omitempty"`
+}
+
+// loadKeys reads the keys file, a JSON list of keys, and returns them by
+// hash.
+func loadKeys(file string) (map[string]*apiKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*apiKey
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	keys := make(map[string]*apiKey)
+	names := make(map[string]bool)
+	for i, k := range list {
+		k.Hash = strings.ToLower(k.Hash)
+		if b, err := hex.DecodeString(k.Hash); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("%s: key %d: bad hash, a hex SHA-256 was expected", file, i)
+		}
+		if k.Name == "" || names[k.Name] {
+			return nil, fmt.Errorf("%s: key %d: names have to be unique and not empty", file, i)
+		}
+		names[k.Name] = true
+		keys[k.Hash] = k
+	}
+
+	return keys, nil
+}
+
+// newKey prints a new random secret and the hash to put in the keys file.
+func newKey() error {
+	var b [24]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return err
+	}
+	secret := hex.EncodeToString(b[:])
+	fmt.Printf("secret: %s\nhash:   %s\n", secret, hashSecret(secret))
+	return nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// client is who makes a request, and what it is allowed to do.
+type client struct {
+	id     string  // the name of its key, or anonymous and its IP
+	key    *apiKey // nil for anonymous clients
+	limits limits
+	daily  int     // renders a day, 0 for no quota
+	rate   float64 // requests per second, 0 for no limit
+	burst  int
+}
+
+// Authentication settings.  If apiKeys is nil authentication is off, and
+// every client is anonymous with the server limits and quota.
+var (
+	apiKeys      map[string]*apiKey
+	anonLimits   limits
+	anonDaily    int
+	anonRate     float64
+	anonBurst    int
+	defaultRate  float64 // of keys without a rate, and of everyone if authentication is off
+	defaultBurst int
+	defaultDaily int // renders a day of everyone if authentication is off
+)
+
+type clientKey struct{}
+
+// requestClient returns the client of the request, set by authenticate.
+func requestClient(r *http.Request) *client {
+	if c, ok := r.Context().Value(clientKey{}).(*client); ok {
+		return c
+	}
+	return anonymousClient(r)
+}
+
+func anonymousClient(r *http.Request) *client {
+	c := &client{
+		id:     "anonymous " + clientIP(r),
+		limits: renderLimits,
+		daily:  defaultDaily,
+		rate:   defaultRate,
+		burst:  defaultBurst,
+	}
+	if apiKeys != nil {
+		c.limits, c.daily = anonLimits, anonDaily
+		c.rate, c.burst = anonRate, anonBurst
+	}
+	return c
+}
+
+func keyClient(k *apiKey) *client {
+	c := &client{
+		id:     k.Name,
+		key:    k,
+		limits: renderLimits,
+		daily:  k.DailyRenders,
+		rate:   defaultRate,
+		burst:  defaultBurst,
+	}
+	if k.MaxPixels > 0 {
+		c.limits.maxPixels = k.MaxPixels
+	}
+	if k.MaxFrames > 0 {
+		c.limits.maxFrames = k.MaxFrames
+	}
+	if k.MaxSamples > 0 {
+		c.limits.maxSamples = k.MaxSamples
+	}
+	if k.MaxMemory > 0 {
+		c.limits.maxMemory = k.MaxMemory
+	}
+	if k.Rate > 0 {
+		c.rate, c.burst = k.Rate, k.Burst
+	}
+	if c.burst < 1 {
+		c.burst = 1
+	}
+	return c
+}
+
+// requestSecret returns the API key secret of the request, from the
+// X-API-Key header, a bearer token or the api_key form of the URL.
+func requestSecret(r *http.Request) string {
+	if s := r.Header.Get("X-API-Key"); s != "" {
+		return s
+	}
+	if s, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(s)
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// authenticate returns a handler that finds out the client of every
+// request.  Requests with unknown keys are answered with 401
+// Unauthorized; requests without keys are anonymous.
+func authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := anonymousClient(r)
+		if secret := requestSecret(r); secret != "" && apiKeys != nil {
+			k, ok := apiKeys[hashSecret(secret)]
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="lissajous"`)
+				writeError(w, r, withStatus(http.StatusUnauthorized,
+					fmt.Errorf("unknown API key")))
+				return
+			}
+			c = keyClient(k)
+		}
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, c)))
+	})
+}
+
+// requireKey returns an error unless the client of the request has an API
+// key, an admin one if admin is true.  Everyone is allowed when
+// authentication is off.
+func requireKey(w http.ResponseWriter, r *http.Request, admin bool) error {
+	if apiKeys == nil {
+		return nil
+	}
+	c := requestClient(r)
+	if c.key == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="lissajous"`)
+		return withStatus(http.StatusUnauthorized,
+			fmt.Errorf("changes need an API key"))
+	}
+	if admin && !c.key.Admin {
+		return withStatus(http.StatusForbidden,
+			fmt.Errorf("only admin API keys can do this"))
+	}
+	return nil
+}
+
+// checkLimits returns an error if drawing frames frames of conf would
+// exceed the limits of the client of the request.
+func checkLimits(r *http.Request, conf *lissajous.Conf, frames int) error {
+	if err := requestClient(r).limits.check(conf, frames); err != nil {
+		return withStatus(http.StatusRequestEntityTooLarge, err)
+	}
+	return nil
+}
+
+// usageDays is how many days of usage are kept.
+const usageDays = 7
+
+// clientUsage is what a client did in a day.
+type clientUsage struct {
+	Renders  int   `json:"renders"`
+	Bytes    int64 `json:"bytes"`
+	Rejected int   `json:"rejected"` // renders over the quota
+}
+
+// usageTracker counts the renders of every client, by day, in UTC, to
+// enforce the daily quotas.
+type usageTracker struct {
+	mu   sync.Mutex
+	days map[string]map[string]*clientUsage // by day, and then by client
+}
+
+var usage = &usageTracker{days: make(map[string]map[string]*clientUsage)}
+
+func today(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+// get returns the usage of the client today, forgetting old days.  The
+// lock has to be held.
+func (u *usageTracker) get(id string, now time.Time) *clientUsage {
+	day := today(now)
+	clients, ok := u.days[day]
+	if !ok {
+		clients = make(map[string]*clientUsage)
+		u.days[day] = clients
+		oldest := today(now.AddDate(0, 0, -usageDays+1))
+		for d := range u.days {
+			if d < oldest {
+				delete(u.days, d)
+			}
+		}
+	}
+
+	cu, ok := clients[id]
+	if !ok {
+		cu = new(clientUsage)
+		clients[id] = cu
+	}
+	return cu
+}
+
+// take counts n renders of the client, or returns an error if they would
+// exceed its quota.
+func (u *usageTracker) take(c *client, n int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	cu := u.get(c.id, now)
+	if c.daily > 0 && cu.Renders+n > c.daily {
+		cu.Rejected += n
+		utc := now.UTC()
+		midnight := time.Date(utc.Year(), utc.Month(), utc.Day()+1, 0, 0, 0, 0, time.UTC)
+		return &httpError{
+			status: http.StatusTooManyRequests,
+			err: fmt.Errorf("daily quota of %d renders exceeded, %d were made today",
+				c.daily, cu.Renders),
+			retryAfter: midnight.Sub(now),
+		}
+	}
+	cu.Renders += n
+
+	return nil
+}
+
+func (u *usageTracker) addBytes(c *client, n int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.get(c.id, time.Now()).Bytes += int64(n)
+}
+
+// usageReport is the usage of a day.
+type usageReport struct {
+	Day     string                  `json:"day"`
+	Clients map[string]*clientUsage `json:"clients"`
+	Days    []string                `json:"days"` // with usage
+}
+
+// adminUsage serves the usage of the clients, of the day of the day form,
+// YYYY-MM-DD, today by default.  Only admin keys can see it or, when
+// authentication is off, requests from this host, so the usage of
+// anonymous clients by IP can be checked too.
+func adminUsage(w http.ResponseWriter, r *http.Request) {
+	allowed := localRequest(r)
+	if apiKeys != nil {
+		c := requestClient(r)
+		allowed = c.key != nil && c.key.Admin
+	}
+	if !allowed {
+		writeError(w, r, withStatus(http.StatusForbidden, fmt.Errorf(
+			"usage is only shown to admin API keys, or to local requests without them")))
+		return
+	}
+
+	day := r.URL.Query().Get("day")
+	if day == "" {
+		day = today(time.Now())
+	}
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		writeError(w, r, withStatus(http.StatusBadRequest, fmt.Errorf(
+			"bad day value, YYYY-MM-DD was expected but %q was found", day)))
+		return
+	}
+
+	report := usageReport{Day: day, Clients: make(map[string]*clientUsage)}
+	usage.mu.Lock()
+	for id, cu := range usage.days[day] {
+		copied := *cu
+		report.Clients[id] = &copied
+	}
+	for d := range usage.days {
+		report.Days = append(report.Days, d)
+	}
+	usage.mu.Unlock()
+	sort.Strings(report.Days)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusOK, report)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminUsageAccess(t *testing.T) {
+	oldKeys := apiKeys
+	t.Cleanup(func() { apiKeys = oldKeys })
+	keys := map[string]*apiKey{
+		hashSecret("admin"): {Name: "admin", Admin: true},
+		hashSecret("user"):  {Name: "user"},
+	}
+
+	h := authenticate(http.HandlerFunc(adminUsage))
+	for _, tc := range []struct {
+		name       string
+		keys       map[string]*apiKey
+		remoteAddr string
+		key        string
+		want       int
+	}{
+		{"local without keys", nil, "127.0.0.1:1234", "", http.StatusOK},
+		{"local ipv6 without keys", nil, "[::1]:1234", "", http.StatusOK},
+		{"remote without keys", nil, "192.0.2.1:1234", "", http.StatusForbidden},
+		{"local with keys", keys, "127.0.0.1:1234", "", http.StatusForbidden},
+		{"user key", keys, "192.0.2.1:1234", "user", http.StatusForbidden},
+		{"admin key", keys, "192.0.2.1:1234", "admin", http.StatusOK},
+	} {
+		apiKeys = tc.keys
+		r := httptest.NewRequest(http.MethodGet, "/admin/usage", nil)
+		r.RemoteAddr = tc.remoteAddr
+		if tc.key != "" {
+			r.Header.Set("X-API-Key", tc.key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+// maxBatch bounds the figures of a batch.
+const maxBatch = 1000
+
+// Bounds of the work of a whole batch, as its figures are rendered one
+// after the other by a single request.
+var (
+	maxBatchSamples float64 // points drawn by all the figures
+	maxBatchPixels  float64 // pixels of all the frames of all the figures
+)
+
+// sweep describes a batch as every combination of the values of some
+// parameters of a base figure.
+type sweep struct {
+	Base  json.RawMessage `json:"base"`
+	Sweep []sweepAxis     `json:"sweep"`
+}
+
+// sweepAxis is a parameter and its values: either the given ones, or steps
+// values evenly spaced from from to to, both included.
+type sweepAxis struct {
+	Param  string   `json:"param"`
+	Values []string `json:"values,omitempty"`
+	From   float64  `json:"from,omitempty"`
+	To     float64  `json:"to,omitempty"`
+	Steps  int      `json:"steps,omitempty"`
+}
+
+func (a *sweepAxis) values() ([]string, error) {
+	if len(a.Values) > maxBatch {
+		return nil, fmt.Errorf("sweep of %s: too many values, %d were given, the limit is %d",
+			a.Param, len(a.Values), maxBatch)
+	}
+	if len(a.Values) > 0 {
+		return a.Values, nil
+	}
+	// checked before allocating them, as steps comes from the request
+	if a.Steps < 2 || a.Steps > maxBatch {
+		return nil, fmt.Errorf("sweep of %s: give values, or from, to and from 2 to %d steps",
+			a.Param, maxBatch)
+	}
+
+	values := make([]string, a.Steps)
+	for i := range values {
+		v := a.From + (a.To-a.From)*float64(i)/float64(a.Steps-1)
+		values[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return values, nil
+}
+
+// batchConfs parses the figures of a batch: a JSON list of figures, like
+// the JSON bodies of /, or a sweep.
+func batchConfs(body []byte) ([]*lissajous.Conf, error) {
+	body = bytes.TrimSpace(body)
+
+	if len(body) > 0 && body[0] == '[' {
+		var list []json.RawMessage
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, fmt.Errorf("bad JSON body: %s", err)
+		}
+		if len(list) > maxBatch {
+			return nil, fmt.Errorf("too many figures, %d were given, the limit is %d",
+				len(list), maxBatch)
+		}
+		confs := make([]*lissajous.Conf, len(list))
+		for i, raw := range list {
+			var err error
+			if confs[i], err = jsonToConf(bytes.NewReader(raw)); err != nil {
+				return nil, fmt.Errorf("figure %d: %s", i, err)
+			}
+		}
+		return confs, nil
+	}
+
+	var s sweep
+	if err := json.Unmarshal(body, &s); err != nil {
+		return nil, fmt.Errorf("bad JSON body: %s", err)
+	}
+	return s.confs()
+}
+
+// confs returns every combination of the values of the axes, the first
+// axis changing the slowest.  Parameters of layers change in the base
+// figure and in all its layers.
+func (s *sweep) confs() ([]*lissajous.Conf, error) {
+	base := lissajous.DefaultConf()
+	if len(s.Base) > 0 {
+		var err error
+		if base, err = jsonToConf(bytes.NewReader(s.Base)); err != nil {
+			return nil, fmt.Errorf("base: %s", err)
+		}
+	}
+
+	// the size of the sweep is known before building any figure
+	params := make([]*lissajous.Param, len(s.Sweep))
+	values := make([][]string, len(s.Sweep))
+	n := 1
+	for i, axis := range s.Sweep {
+		if params[i] = lissajous.LookupParam(axis.Param); params[i] == nil {
+			return nil, fmt.Errorf("sweep of unknown parameter %q", axis.Param)
+		}
+		var err error
+		if values[i], err = axis.values(); err != nil {
+			return nil, err
+		}
+		if n *= len(values[i]); n > maxBatch {
+			return nil, fmt.Errorf("too many figures, the sweep has more than %d",
+				maxBatch)
+		}
+	}
+
+	confs := []*lissajous.Conf{base}
+	for i, p := range params {
+		var next []*lissajous.Conf
+		for _, conf := range confs {
+			for _, v := range values[i] {
+				c := conf.Clone()
+				if err := p.Set(c, &c.Layer, v); err != nil {
+					return nil, fmt.Errorf("sweep of %s: %s", p.Name, err)
+				}
+				if p.Layer {
+					for j := range c.Layers {
+						if err := p.Set(c, &c.Layers[j], v); err != nil {
+							return nil, fmt.Errorf("sweep of %s: %s", p.Name, err)
+						}
+					}
+				}
+				next = append(next, c)
+			}
+		}
+		confs = next
+	}
+
+	return confs, nil
+}
+
+// batchManifest describes the files of a batch archive.
+type batchManifest struct {
+	Format string      `json:"format"`
+	Files  []batchFile `json:"files"`
+}
+
+type batchFile struct {
+	Name  string          `json:"name"`
+	Conf  *lissajous.Conf `json:"conf"`
+	Bytes int             `json:"bytes,omitempty"`
+	ETag  string          `json:"etag,omitempty"`
+	Error string          `json:"error,omitempty"` // and then there is no file
+}
+
+// batchHandler renders many figures at once: POST /batch takes a JSON
+// list of figures, or a sweep, and answers with a ZIP archive of their
+// renders in the format of the format form, still formats drawing the
+// frame of the frame form, and a manifest.json describing them.
+//
+// Figures are rendered one by one, as the archive is streamed, so only one
+// of them is in memory at a time.  As the status is sent before rendering
+// them, figures that fail to render are only reported in the manifest.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := readJSONBody(w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	confs, err := batchConfs(body)
+	if err != nil {
+		writeError(w, r, withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	f := formatGIF
+	if name := r.URL.Query().Get("format"); name != "" {
+		if f = lookupFormat(name, figureFormats); f == nil {
+			writeError(w, r, withStatus(http.StatusBadRequest, fmt.Errorf(
+				"bad format value, one of %s was expected but %q was found",
+				formatNames(figureFormats), name)))
+			return
+		}
+	}
+	var frame int
+	var samples, pixels float64
+	for i, conf := range confs {
+		frames := renderedFrames(f, conf)
+		if err := checkLimits(r, conf, frames); err != nil {
+			writeError(w, r, withStatus(http.StatusRequestEntityTooLarge,
+				fmt.Errorf("figure %d: %s", i, err)))
+			return
+		}
+		if frame, err = frameForm(r, conf); err != nil {
+			writeError(w, r, withStatus(http.StatusBadRequest,
+				fmt.Errorf("figure %d: %s", i, err)))
+			return
+		}
+		samples += frameSamples(conf, frames)
+		pixels += framePixels(conf, frames)
+	}
+	if err := checkBatch(samples, pixels); err != nil {
+		writeError(w, r, withStatus(http.StatusRequestEntityTooLarge, err))
+		return
+	}
+
+	c := requestClient(r)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="lissajous.zip"`)
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	zw := zip.NewWriter(w)
+	manifest := batchManifest{Format: f.name}
+
+	for i, conf := range confs {
+		if r.Context().Err() != nil {
+			return // the client is gone
+		}
+		// every figure gets as long as a render of its own
+		deadline := time.Now().Add(renderQueue.wait + renderTimeout + time.Minute)
+		if err := rc.SetWriteDeadline(deadline); err != nil {
+			log.Print(err)
+		}
+
+		file := batchFile{
+			Name: fmt.Sprintf("%04d.%s", i, f.ext),
+			Conf: conf,
+		}
+		entry, err := cachedRender(c, f, frame, conf)
+		if err != nil {
+			file.Error = err.Error()
+			manifest.Files = append(manifest.Files, file)
+			continue
+		}
+		file.Bytes, file.ETag = len(entry.body), entry.etag
+		usage.addBytes(c, len(entry.body))
+
+		if err := writeZipFile(zw, file.Name, compressible(f.mediaType), entry.body); err != nil {
+			return // the client is gone
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if err := writeZipFile(zw, "manifest.json", true, append(data, '\n')); err != nil {
+		return
+	}
+	if err := zw.Close(); err != nil {
+		log.Print(err)
+	}
+}
+
+// checkBatch returns an error if the figures of a batch, drawing samples
+// points and pixels pixels in total, are more work than a batch can take.
+func checkBatch(samples, pixels float64) error {
+	if samples > maxBatchSamples {
+		return fmt.Errorf(
+			"too many samples, drawing the whole batch takes %.0f, the limit is %.0f; "+
+				"send fewer or smaller figures",
+			samples, maxBatchSamples)
+	}
+	if pixels > maxBatchPixels {
+		return fmt.Errorf(
+			"too many pixels, the frames of the whole batch have %.0f, the limit is %.0f; "+
+				"send fewer or smaller figures",
+			pixels, maxBatchPixels)
+	}
+	return nil
+}
+
+// writeZipFile adds a file to the archive, compressed if compress is true.
+func writeZipFile(zw *zip.Writer, name string, compress bool, body []byte) error {
+	method := zip.Store
+	if compress {
+		method = zip.Deflate
+	}
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   method,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(body)
+	return err
+}
+
+// compressible tells if files of the media type are worth compressing;
+// images other than SVG are compressed already.
+func compressible(mediaType string) bool {
+	return !strings.HasPrefix(mediaType, "image/") || mediaType == "image/svg+xml"
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+// cacheEntry is a rendered figure.
+type cacheEntry struct {
+	body []byte
+	etag string // strong entity tag, a hash of the body
+}
+
+func newCacheEntry(body []byte) *cacheEntry {
+	sum := sha256.Sum256(body)
+	return &cacheEntry{
+		body: body,
+		etag: `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`,
+	}
+}
+
+// cacheKey identifies a render: the format, the frame for still formats,
+// and the canonical query string of the figure, so equal figures get equal
+// keys no matter how they were requested.
+func cacheKey(f *format, frame int, conf *lissajous.Conf) string {
+	if !f.still {
+		frame = 0
+	}
+	return fmt.Sprintf("%s/%d?%s", f.id, frame, conf.MarshalQuery())
+}
+
+// renderCache keeps the most recently used renders in memory, up to a
+// number of bytes, and optionally on disk, in a directory.
+type renderCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	bytes    int
+	lru      *list.List // of *cacheItem, most recently used first
+	items    map[string]*list.Element
+
+	dir *diskCache // nil if there is no disk tier
+}
+
+type cacheItem struct {
+	key   string
+	entry *cacheEntry
+}
+
+func newRenderCache(maxBytes int, dir *diskCache) *renderCache {
+	return &renderCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+		dir:      dir,
+	}
+}
+
+// get returns the render with the given key, or nil if it is not cached.
+func (c *renderCache) get(key string) *cacheEntry {
+	c.mu.Lock()
+	if e, ok := c.items[key]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*cacheItem).entry
+	}
+	c.mu.Unlock()
+
+	if c.dir == nil {
+		return nil
+	}
+	body, ok := c.dir.get(key)
+	if !ok {
+		return nil
+	}
+	entry := newCacheEntry(body)
+	c.addMemory(key, entry)
+
+	return entry
+}
+
+// size returns the bytes of the renders in memory.
+func (c *renderCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+func (c *renderCache) add(key string, entry *cacheEntry) {
+	c.addMemory(key, entry)
+	if c.dir != nil {
+		c.dir.add(key, entry.body)
+	}
+}
+
+func (c *renderCache) addMemory(key string, entry *cacheEntry) {
+	if len(entry.body) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.lru.PushFront(&cacheItem{key, entry})
+	c.bytes += len(entry.body)
+
+	for c.bytes > c.maxBytes {
+		oldest := c.lru.Back()
+		item := oldest.Value.(*cacheItem)
+		c.lru.Remove(oldest)
+		delete(c.items, item.key)
+		c.bytes -= len(item.entry.body)
+	}
+}
+
+// diskCache keeps renders as files named after the hash of their keys, up
+// to a number of bytes; the least recently modified files are removed
+// first.
+type diskCache struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	bytes    int64
+}
+
+func newDiskCache(path string, maxBytes int64) (*diskCache, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	d := &diskCache{path: path, maxBytes: maxBytes}
+	files, err := d.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		d.bytes += f.Size()
+	}
+
+	return d, nil
+}
+
+func (d *diskCache) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.path, hex.EncodeToString(sum[:]))
+}
+
+func (d *diskCache) get(key string) ([]byte, bool) {
+	body, err := os.ReadFile(d.file(key))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Print(err)
+		}
+		return nil, false
+	}
+
+	// mark it as recently used
+	now := time.Now()
+	if err := os.Chtimes(d.file(key), now, now); err != nil {
+		log.Print(err)
+	}
+
+	return body, true
+}
+
+// add writes the file atomically, so readers never see partial renders,
+// not even after a crash.
+func (d *diskCache) add(key string, body []byte) {
+	if int64(len(body)) > d.maxBytes {
+		return
+	}
+
+	tmp, err := os.CreateTemp(d.path, "tmp-")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	_, err = tmp.Write(body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.file(key))
+	}
+	if err != nil {
+		log.Print(err)
+		os.Remove(tmp.Name())
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.bytes += int64(len(body))
+	if d.bytes > d.maxBytes {
+		d.evict()
+	}
+}
+
+// evict removes the least recently used files until the cache fits in its
+// size.  Counting the bytes again also fixes the count after files are
+// replaced.
+func (d *diskCache) evict() {
+	files, err := d.files()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	d.bytes = 0
+	for _, f := range files {
+		d.bytes += f.Size()
+	}
+	for _, f := range files {
+		if d.bytes <= d.maxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(d.path, f.Name())); err != nil {
+			log.Print(err)
+			continue
+		}
+		d.bytes -= f.Size()
+	}
+}
+
+func (d *diskCache) files() ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []os.FileInfo
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), "tmp-") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+	}
+
+	return files, nil
+}
+
+// flight collapses concurrent calls with the same key into a single one.
+type flight struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done  chan struct{}
+	entry *cacheEntry
+	err   error
+}
+
+func newFlight() *flight {
+	return &flight{calls: make(map[string]*flightCall)}
+}
+
+// do calls fn, unless there is already a call with the same key in
+// progress, in which case it waits for it and returns its results.
+func (f *flight) do(key string, fn func() (*cacheEntry, error)) (*cacheEntry, error) {
+	f.mu.Lock()
+	if c, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		<-c.done
+		return c.entry, c.err
+	}
+	c := &flightCall{done: make(chan struct{})}
+	f.calls[key] = c
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		close(c.done)
+	}()
+	// if fn panics, the waiters get an error, and the panic goes on
+	defer func() {
+		if v := recover(); v != nil {
+			c.entry, c.err = nil, fmt.Errorf("the render failed: %v", v)
+			panic(v)
+		}
+	}()
+
+	c.entry, c.err = fn()
+	return c.entry, c.err
+}
+
+// serveEntry answers the request with the render, or with 304 Not Modified
+// if the client already has it.
+func serveEntry(w http.ResponseWriter, r *http.Request, mediaType string, entry *cacheEntry) {
+	h := w.Header()
+	h.Set("ETag", entry.etag)
+	h.Set("Cache-Control",
+		"public, max-age="+strconv.Itoa(int(cacheMaxAge.Seconds())))
+
+	if etagMatch(r.Header.Get("If-None-Match"), entry.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeBody(w, r, mediaType, entry.body)
+}
+
+// etagMatch tells if an If-None-Match header matches the entity tag, with
+// the weak comparison RFC 7232 asks for.
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
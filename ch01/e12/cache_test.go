@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRenderCacheEviction(t *testing.T) {
+	c := newRenderCache(10, nil)
+	c.add("a", newCacheEntry([]byte("aaaa")))
+	c.add("b", newCacheEntry([]byte("bbbb")))
+	c.get("a") // now b is the least recently used
+	c.add("c", newCacheEntry([]byte("cccc")))
+	c.add("big", newCacheEntry([]byte("too big to fit")))
+
+	for key, cached := range map[string]bool{"a": true, "b": false, "c": true, "big": false} {
+		if got := c.get(key) != nil; got != cached {
+			t.Errorf("%s cached: got %v, want %v", key, got, cached)
+		}
+	}
+	if got := c.size(); got != 8 {
+		t.Errorf("size: got %d, want 8", got)
+	}
+}
+
+func TestRenderCacheDisk(t *testing.T) {
+	disk, err := newDiskCache(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newRenderCache(4, disk) // too small for any of them
+
+	c.add("x", newCacheEntry([]byte("xxxxxx")))
+	entry := c.get("x")
+	if entry == nil || string(entry.body) != "xxxxxx" {
+		t.Fatalf("x from disk: got %v", entry)
+	}
+
+	// the least recently used file goes first once the disk is full
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(disk.file("x"), past, past); err != nil {
+		t.Fatal(err)
+	}
+	c.add("y", newCacheEntry([]byte("yyyyyy")))
+	if c.get("x") != nil {
+		t.Errorf("x is still cached, it should have been evicted")
+	}
+	if entry := c.get("y"); entry == nil || string(entry.body) != "yyyyyy" {
+		t.Errorf("y from disk: got %v", entry)
+	}
+
+	// the files outlive the cache
+	again, err := newDiskCache(disk.path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.bytes != 6 {
+		t.Errorf("bytes after reopening: got %d, want 6", again.bytes)
+	}
+}
+
+func TestFlightPanic(t *testing.T) {
+	f := newFlight()
+	started, release := make(chan struct{}), make(chan struct{})
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		f.do("k", func() (*cacheEntry, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+
+	<-started
+	f.mu.Lock()
+	call := f.calls["k"] // what waiters wait for
+	f.mu.Unlock()
+	close(release)
+
+	if v := <-panicked; v != "boom" {
+		t.Errorf("the panic did not go on: got %v", v)
+	}
+	select {
+	case <-call.done:
+	case <-time.After(time.Second):
+		t.Fatal("waiters are still waiting after the panic")
+	}
+	if call.err == nil {
+		t.Error("waiters got no error after the panic")
+	}
+
+	entry, err := f.do("k", func() (*cacheEntry, error) {
+		return newCacheEntry([]byte("ok")), nil
+	})
+	if err != nil || string(entry.body) != "ok" {
+		t.Errorf("call after the panic: got %v, %v", entry, err)
+	}
+}
+
+func TestETagMatch(t *testing.T) {
+	const etag = `"abc"`
+	for _, tc := range []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"*", true},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"x", "abc"`, true},
+		{`"x","y"`, false},
+		{`"abcd"`, false},
+	} {
+		if got := etagMatch(tc.header, etag); got != tc.want {
+			t.Errorf("etagMatch(%q): got %v, want %v", tc.header, got, tc.want)
+		}
+	}
+}
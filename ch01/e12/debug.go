@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bounds of the debug endpoints, so they cannot be used to exhaust the
+// server.
+const (
+	maxDebugBody      = 1 << 20   // bytes echoed back
+	maxDebugBytes     = 100 << 20 // bytes of /debug/bytes
+	maxDebugLines     = 10000     // lines of /debug/stream
+	maxDebugDelay     = 30 * time.Second
+	maxDebugRedirects = 32
+)
+
+// debugHandler serves the request inspector, a target for testing HTTP
+// clients, only when the server runs with -debug, as it redirects anywhere:
+//
+//	/debug/echo               the request, as text or JSON
+//	/debug/status/{code}      an empty response with the status
+//	/debug/delay/{duration}   the request, after the delay
+//	/debug/redirect/{n}       n redirects, then /debug/echo
+//	/debug/redirect-to?url=   a redirect to the url, status= sets its status
+//	/debug/bytes/{n}          n random bytes, seed= makes them repeatable
+//	/debug/stream/{n}         n JSON lines, one every delay=
+//	/debug/gzip               the request as gzip compressed JSON
+func debugHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/debug/")
+	name, arg := path, ""
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		name, arg = path[:i], path[i+1:]
+	}
+
+	var err error
+	switch name {
+	case "", "echo":
+		err = debugEcho(w, r)
+	case "status":
+		err = debugStatus(w, r, arg)
+	case "delay":
+		err = debugDelay(w, r, arg)
+	case "redirect":
+		err = debugRedirect(w, r, arg)
+	case "redirect-to":
+		err = debugRedirectTo(w, r)
+	case "bytes":
+		err = debugBytes(w, r, arg)
+	case "stream":
+		err = debugStream(w, r, arg)
+	case "gzip":
+		err = debugGzip(w, r)
+	default:
+		err = withStatus(http.StatusNotFound,
+			fmt.Errorf("%s not found", r.URL.Path))
+	}
+	if err != nil {
+		writeError(w, r, err)
+	}
+}
+
+// debugAPI describes the request inspector in /openapi.json.  Echoes take
+// any method, GET and POST stand for all of them.
+var debugAPI = func() []apiPath {
+	get := []string{http.MethodGet}
+	echoTypes := []string{"text/plain", "application/json"}
+	return []apiPath{
+		{
+			path: "/debug/echo", methods: []string{http.MethodGet, http.MethodPost},
+			summary: "Returns the request, as text or as JSON",
+			forms:   []apiForm{{"format", "string", "json to answer with JSON"}},
+			types:   echoTypes,
+		},
+		{
+			path: "/debug/status/{code}", methods: get,
+			summary: "Returns an empty response with the status",
+			forms:   []apiForm{{"code", "integer", "status, from 200 to 599"}},
+		},
+		{
+			path: "/debug/delay/{duration}", methods: get,
+			summary: "Returns the request after the delay",
+			forms: []apiForm{{"duration", "string",
+				"delay, like 1.5s, up to " + maxDebugDelay.String()}},
+			types: echoTypes,
+		},
+		{
+			path: "/debug/redirect/{n}", methods: get,
+			summary: "Redirects n times, and then to /debug/echo",
+			forms:   []apiForm{{"n", "integer", "redirects, up to " + strconv.Itoa(maxDebugRedirects)}},
+			status:  http.StatusFound,
+		},
+		{
+			path: "/debug/redirect-to", methods: get,
+			summary: "Redirects to the URL",
+			forms: []apiForm{
+				{"url", "string", "where to redirect to"},
+				{"status", "integer", "status of the redirect, from 300 to 399, 302 by default"},
+			},
+			status: http.StatusFound,
+		},
+		{
+			path: "/debug/bytes/{n}", methods: get,
+			summary: "Returns n random bytes",
+			forms: []apiForm{
+				{"n", "integer", "bytes, up to " + strconv.Itoa(maxDebugBytes)},
+				{"seed", "integer", "seed of the bytes, to repeat them"},
+			},
+			types: []string{"application/octet-stream"},
+		},
+		{
+			path: "/debug/stream/{n}", methods: get,
+			summary: "Streams n JSON lines",
+			forms: []apiForm{
+				{"n", "integer", "lines, up to " + strconv.Itoa(maxDebugLines)},
+				{"delay", "string", "delay between lines, like 100ms"},
+			},
+			types: []string{"application/x-ndjson"},
+		},
+		{
+			path: "/debug/gzip", methods: get,
+			summary: "Returns the request as gzip compressed JSON",
+			types:   []string{"application/json"},
+		},
+	}
+}()
+
+// echo is the description of a request.
+type echo struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Proto      string      `json:"proto"`
+	Host       string      `json:"host"`
+	RemoteAddr string      `json:"remoteAddr"`
+	Header     http.Header `json:"header"`
+	Form       url.Values  `json:"form"`
+	Body       string      `json:"body"`
+	Truncated  bool        `json:"truncated,omitempty"` // the body was longer than shown
+	TLS        *echoTLS    `json:"tls,omitempty"`
+}
+
+type echoTLS struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipherSuite"`
+	ServerName  string `json:"serverName"`
+	Protocol    string `json:"protocol"` // negotiated with ALPN
+}
+
+// secretHeaders are left out of echoes, so they cannot be read back by
+// pages or proxies that see the responses.
+var secretHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "X-API-Key"}
+
+func newEcho(r *http.Request) (*echo, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDebugBody+1))
+	if err != nil {
+		return nil, withStatus(http.StatusBadRequest, err)
+	}
+	truncated := len(body) > maxDebugBody
+	if truncated {
+		body = body[:maxDebugBody]
+	}
+
+	// the form is parsed from a copy, so the body can be echoed too
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err := r.ParseForm(); err != nil {
+		return nil, withStatus(http.StatusBadRequest, err)
+	}
+
+	header := r.Header.Clone()
+	for _, k := range secretHeaders {
+		header.Del(k)
+	}
+	r.Form.Del("api_key")
+
+	e := &echo{
+		Method:     r.Method,
+		URL:        loggedURI(r),
+		Proto:      r.Proto,
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+		Header:     header,
+		Form:       r.Form,
+		Body:       string(body),
+		Truncated:  truncated,
+	}
+	if r.TLS != nil {
+		e.TLS = &echoTLS{
+			Version:     tls.VersionName(r.TLS.Version),
+			CipherSuite: tls.CipherSuiteName(r.TLS.CipherSuite),
+			ServerName:  r.TLS.ServerName,
+			Protocol:    r.TLS.NegotiatedProtocol,
+		}
+	}
+
+	return e, nil
+}
+
+// text writes the description like the original dumpRequest did, sorted so
+// it can be compared.
+func (e *echo) text(w io.Writer) {
+	fmt.Fprintf(w, "%s %s %s\n", e.Method, e.URL, e.Proto)
+	for _, k := range sortedKeys(e.Header) {
+		fmt.Fprintf(w, "Header[%q] = %q\n", k, e.Header[k])
+	}
+	fmt.Fprintf(w, "Host = %q\n", e.Host)
+	fmt.Fprintf(w, "RemoteAddr = %q\n", e.RemoteAddr)
+	for _, k := range sortedKeys(e.Form) {
+		fmt.Fprintf(w, "Form[%q] = %q\n", k, e.Form[k])
+	}
+	if e.TLS != nil {
+		fmt.Fprintf(w, "TLS = %s %s, server name %q, protocol %q\n",
+			e.TLS.Version, e.TLS.CipherSuite, e.TLS.ServerName, e.TLS.Protocol)
+	}
+	fmt.Fprintf(w, "Body = %q\n", e.Body)
+	if e.Truncated {
+		fmt.Fprintf(w, "Body truncated to %d bytes\n", maxDebugBody)
+	}
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// debugEcho answers with the description of the request, as JSON if the
+// client accepts it or asks for it with format=json, as text otherwise.
+func debugEcho(w http.ResponseWriter, r *http.Request) error {
+	e, err := newEcho(r)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Vary", "Accept")
+
+	if r.URL.Query().Get("format") == "json" || acceptsJSON(r) {
+		writeJSON(w, r, http.StatusOK, e)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	e.text(&buf)
+	writeBody(w, r, "text/plain; charset=utf-8", buf.Bytes())
+	return nil
+}
+
+func debugStatus(w http.ResponseWriter, r *http.Request, arg string) error {
+	status, err := strconv.Atoi(arg)
+	if err != nil || status < 200 || status > 599 {
+		return withStatus(http.StatusBadRequest, fmt.Errorf(
+			"bad status, an int in [200, 599] was expected but %q was found", arg))
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	return nil
+}
+
+func debugDelay(w http.ResponseWriter, r *http.Request, arg string) error {
+	d, err := time.ParseDuration(arg)
+	if err != nil || d < 0 || d > maxDebugDelay {
+		return withStatus(http.StatusBadRequest, fmt.Errorf(
+			"bad delay, a duration in [0s, %s] was expected but %q was found",
+			maxDebugDelay, arg))
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+		return nil // nobody is listening anymore
+	}
+
+	return debugEcho(w, r)
+}
+
+func debugRedirect(w http.ResponseWriter, r *http.Request, arg string) error {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > maxDebugRedirects {
+		return withStatus(http.StatusBadRequest, fmt.Errorf(
+			"bad redirects, an int in [1, %d] was expected but %q was found",
+			maxDebugRedirects, arg))
+	}
+
+	next := "/debug/echo"
+	if n > 1 {
+		next = fmt.Sprintf("/debug/redirect/%d", n-1)
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+	return nil
+}
+
+func debugRedirectTo(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	target := q.Get("url")
+	if target == "" {
+		return withStatus(http.StatusBadRequest, fmt.Errorf("missing url"))
+	}
+
+	status := http.StatusFound
+	if s := q.Get("status"); s != "" {
+		var err error
+		status, err = strconv.Atoi(s)
+		if err != nil || status < 300 || status > 399 {
+			return withStatus(http.StatusBadRequest, fmt.Errorf(
+				"bad status, an int in [300, 399] was expected but %q was found", s))
+		}
+	}
+
+	http.Redirect(w, r, target, status)
+	return nil
+}
+
+func debugBytes(w http.ResponseWriter, r *http.Request, arg string) error {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 0 || n > maxDebugBytes {
+		return withStatus(http.StatusBadRequest, fmt.Errorf(
+			"bad size, an int in [0, %d] was expected but %q was found",
+			maxDebugBytes, arg))
+	}
+
+	seed := time.Now().UnixNano()
+	if s := r.URL.Query().Get("seed"); s != "" {
+		if seed, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return withStatus(http.StatusBadRequest, fmt.Errorf(
+				"bad seed, an int was expected but %q was found", s))
+		}
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Content-Length", strconv.Itoa(n))
+	h.Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	if _, err := io.CopyN(w, rand.New(rand.NewSource(seed)), int64(n)); err != nil {
+		log.Print(err)
+	}
+	return nil
+}
+
+// debugStream writes JSON lines as they are produced, flushing each one, so
+// the response is chunked.
+func debugStream(w http.ResponseWriter, r *http.Request, arg string) error {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > maxDebugLines {
+		return withStatus(http.StatusBadRequest, fmt.Errorf(
+			"bad lines, an int in [1, %d] was expected but %q was found",
+			maxDebugLines, arg))
+	}
+
+	var delay time.Duration
+	if s := r.URL.Query().Get("delay"); s != "" {
+		delay, err = time.ParseDuration(s)
+		// delay is bounded before multiplying, so the total cannot overflow
+		if err != nil || delay < 0 || delay > maxDebugDelay ||
+			delay*time.Duration(n) > maxDebugDelay {
+			return withStatus(http.StatusBadRequest, fmt.Errorf(
+				"bad delay, a duration up to %s in total was expected but %q was found",
+				maxDebugDelay, s))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	flusher, _ := w.(http.Flusher)
+
+	for i := 0; i < n; i++ {
+		if i > 0 && delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return nil
+			}
+		}
+		if _, err := fmt.Fprintf(w, `{"line":%d,"time":%q}`+"\n",
+			i, time.Now().UTC().Format(time.RFC3339Nano)); err != nil {
+			return nil // the client is gone
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	return nil
+}
+
+// debugGzip answers with the JSON description of the request, compressed
+// with gzip whether the client asked for it or not.
+func debugGzip(w http.ResponseWriter, r *http.Request) error {
+	e, err := newEcho(r)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(e); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Cache-Control", "no-store")
+	writeBody(w, r, "application/json", buf.Bytes())
+	return nil
+}
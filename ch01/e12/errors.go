@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpError is an error together with the status it has to be answered
+// with.
+type httpError struct {
+	status     int
+	err        error
+	retryAfter time.Duration // if not zero, when the client can try again
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func withStatus(status int, err error) *httpError {
+	return &httpError{status: status, err: err}
+}
+
+// problem is the JSON problem details of RFC 7807.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance"`
+}
+
+// writeError answers the request with err.  The status is the one of err
+// if it is an *httpError and 500 otherwise.  Clients that accept JSON get
+// problem details, the rest get plain text.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*httpError); ok {
+		status = e.status
+		if e.retryAfter > 0 {
+			w.Header().Set("Retry-After",
+				fmt.Sprint(int(e.retryAfter.Seconds()+1)))
+		}
+	}
+	if status == http.StatusInternalServerError {
+		log.Printf("%s %s %s: %s", requestID(r), r.Method, r.URL, err)
+	}
+
+	h := w.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	if !strings.Contains(strings.Join(h.Values("Vary"), ","), "Accept") {
+		h.Add("Vary", "Accept")
+	}
+
+	if !acceptsJSON(r) {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "Error: %s\n", err)
+		return
+	}
+
+	h.Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   err.Error(),
+		Instance: r.URL.RequestURI(),
+	}
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Print(err)
+	}
+}
+
+// acceptsJSON tells if the Accept header of the request lists a JSON media
+// type.
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(part)
+			if err != nil || params["q"] == "0" {
+				continue
+			}
+			switch mediaType {
+			case "application/json", "application/problem+json":
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// allow returns a handler that answers with 405 Method Not Allowed the
+// requests with methods other than the given ones.
+func allow(h http.HandlerFunc, methods ...string) http.HandlerFunc {
+	allowed := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, m := range methods {
+			if r.Method == m {
+				h(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", allowed)
+		writeError(w, r, withStatus(http.StatusMethodNotAllowed,
+			fmt.Errorf("method %s is not allowed, use %s", r.Method, allowed)))
+	}
+}
+
+// exactPath returns a handler that answers with 404 Not Found the requests
+// for paths other than path, as the patterns ending in a slash match whole
+// subtrees.
+func exactPath(path string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			writeError(w, r, withStatus(http.StatusNotFound,
+				fmt.Errorf("%s not found", r.URL.Path)))
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+// format is a way of rendering figures, and the media type of the result.
+type format struct {
+	id        string // unique among all formats
+	name      string // value of the format form that selects it
+	mediaType string
+	ext       string // extension of its files
+	still     bool   // only draws one frame
+	// render draws the figure; still formats draw only the given frame
+	render func(ctx context.Context, w io.Writer, conf *lissajous.Conf, frame int) error
+}
+
+var (
+	formatGIF = &format{
+		id: "gif", name: "gif", mediaType: "image/gif", ext: "gif", still: false,
+		render: func(ctx context.Context, w io.Writer, conf *lissajous.Conf, _ int) error {
+			return lissajous.GifContext(ctx, w, conf)
+		},
+	}
+	formatAPNG = &format{
+		id: "apng", name: "apng", mediaType: "image/apng", ext: "png", still: false,
+		render: func(ctx context.Context, w io.Writer, conf *lissajous.Conf, _ int) error {
+			return lissajous.APNGContext(ctx, w, conf)
+		},
+	}
+	formatPNG = &format{
+		id: "png", name: "png", mediaType: "image/png", ext: "png", still: true,
+		render: func(_ context.Context, w io.Writer, conf *lissajous.Conf, frame int) error {
+			return lissajous.PNG(w, conf, frame)
+		},
+	}
+	formatSVG = &format{
+		id: "svg", name: "svg", mediaType: "image/svg+xml", ext: "svg", still: true,
+		render: func(_ context.Context, w io.Writer, conf *lissajous.Conf, frame int) error {
+			return lissajous.SVG(w, conf, frame)
+		},
+	}
+	formatHPGL = &format{
+		id: "hpgl", name: "hpgl", mediaType: "application/vnd.hp-hpgl", ext: "hpgl", still: true,
+		render: func(_ context.Context, w io.Writer, conf *lissajous.Conf, frame int) error {
+			plot := lissajous.DefaultPlot()
+			plot.Frame = frame
+			return lissajous.HPGL(w, conf, plot)
+		},
+	}
+	formatGCode = &format{
+		id: "gcode", name: "gcode", mediaType: "text/x-gcode", ext: "gcode", still: true,
+		render: func(_ context.Context, w io.Writer, conf *lissajous.Conf, frame int) error {
+			plot := lissajous.DefaultPlot()
+			plot.Frame = frame
+			return lissajous.GCode(w, conf, plot)
+		},
+	}
+	formatExposure = &format{
+		id: "exposure", name: "png", mediaType: "image/png", ext: "png", still: false,
+		render: func(ctx context.Context, w io.Writer, conf *lissajous.Conf, _ int) error {
+			return lissajous.ExposureContext(ctx, w, conf)
+		},
+	}
+)
+
+// figureFormats are the formats of the figures, in order of preference.
+var figureFormats = []*format{
+	formatGIF, formatAPNG, formatPNG, formatSVG, formatHPGL, formatGCode,
+}
+
+// negotiate chooses the format of the response among offers, in order of
+// preference, from the format form, or else from the Accept header.
+func negotiate(r *http.Request, offers []*format) (*format, error) {
+	accept := parseAccept(r.Header.Values("Accept"))
+
+	if name := r.URL.Query().Get("format"); name != "" {
+		for _, f := range offers {
+			if f.name != name {
+				continue
+			}
+			if accept.quality(f.mediaType) == 0 {
+				return nil, withStatus(http.StatusNotAcceptable, fmt.Errorf(
+					"format %s is %s, but the Accept header does not allow it",
+					name, f.mediaType))
+			}
+			return f, nil
+		}
+		return nil, withStatus(http.StatusNotAcceptable, fmt.Errorf(
+			"format %q is not available here, use %s", name, formatNames(offers)))
+	}
+
+	var best *format
+	var bestQ float64
+	for _, f := range offers {
+		if q := accept.quality(f.mediaType); q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	if best == nil {
+		return nil, withStatus(http.StatusNotAcceptable, fmt.Errorf(
+			"none of the accepted media types is available here, use %s",
+			mediaTypes(offers)))
+	}
+
+	return best, nil
+}
+
+// frameForm returns the value of the frame form, the frame drawn by still
+// formats, 0 by default.
+func frameForm(r *http.Request, conf *lissajous.Conf) (int, error) {
+	s := r.URL.Query().Get("frame")
+	if s == "" {
+		return 0, nil
+	}
+
+	frame, err := strconv.Atoi(s)
+	if err != nil || frame < 0 || frame >= conf.NFrames {
+		return 0, withStatus(http.StatusBadRequest, fmt.Errorf(
+			"bad frame value, an int in [0, %d] was expected but %q was found",
+			conf.NFrames-1, s))
+	}
+
+	return frame, nil
+}
+
+// lookupFormat returns the format among offers with the name, or nil if
+// there is none.
+func lookupFormat(name string, offers []*format) *format {
+	for _, f := range offers {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func formatNames(formats []*format) string {
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = f.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// uniqueMediaTypes returns the media types of the formats, without
+// duplicates.
+func uniqueMediaTypes(formats []*format) []string {
+	var types []string
+	seen := make(map[string]bool)
+	for _, f := range formats {
+		if !seen[f.mediaType] {
+			seen[f.mediaType] = true
+			types = append(types, f.mediaType)
+		}
+	}
+	return types
+}
+
+func mediaTypes(formats []*format) string {
+	types := make([]string, len(formats))
+	for i, f := range formats {
+		types[i] = f.mediaType
+	}
+	return strings.Join(types, ", ")
+}
+
+// acceptRange is a media range of an Accept header, like image/* or
+// image/png, with its quality.
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+type acceptHeader []acceptRange
+
+// parseAccept parses the values of Accept headers.  No header at all
+// accepts everything.
+func parseAccept(values []string) acceptHeader {
+	if len(values) == 0 {
+		return acceptHeader{{"*/*", 1}}
+	}
+
+	var result acceptHeader
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			mediaType, params, err := mime.ParseMediaType(part)
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if s, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(s, 64); err != nil {
+					continue
+				}
+			}
+			result = append(result, acceptRange{mediaType, q})
+		}
+	}
+
+	return result
+}
+
+// quality returns the quality of the most specific range that matches the
+// media type, 0 if none does.
+func (h acceptHeader) quality(mediaType string) float64 {
+	typ := mediaType[:strings.IndexByte(mediaType, '/')]
+
+	q, specificity := 0.0, -1
+	for _, r := range h {
+		s := -1
+		switch r.mediaType {
+		case mediaType:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+
+	return q
+}
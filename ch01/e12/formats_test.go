@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptQuality(t *testing.T) {
+	for _, tc := range []struct {
+		accept []string
+		want   map[string]float64 // by media type
+	}{
+		{nil, map[string]float64{"image/gif": 1, "text/x-gcode": 1}},
+		{[]string{"image/png"}, map[string]float64{"image/png": 1, "image/gif": 0}},
+		{
+			[]string{"image/*;q=0.5, image/svg+xml"},
+			map[string]float64{"image/svg+xml": 1, "image/gif": 0.5, "text/x-gcode": 0},
+		},
+		{
+			// the most specific range wins, whatever its order
+			[]string{"image/png;q=0, */*;q=0.1, image/*;q=0.8"},
+			map[string]float64{"image/png": 0, "image/gif": 0.8, "application/vnd.hp-hpgl": 0.1},
+		},
+		{
+			// headers can be repeated, and bad ranges are skipped
+			[]string{"image/gif;q=x, /bad", "image/gif;q=0.3"},
+			map[string]float64{"image/gif": 0.3, "image/png": 0},
+		},
+	} {
+		accept := parseAccept(tc.accept)
+		for mediaType, want := range tc.want {
+			if got := accept.quality(mediaType); got != want {
+				t.Errorf("Accept %q: quality(%s) = %g, want %g",
+					tc.accept, mediaType, got, want)
+			}
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, tc := range []struct {
+		accept string
+		format string // the format form
+		want   *format
+		status int // if the negotiation fails
+	}{
+		{"", "", formatGIF, 0},
+		{"*/*", "", formatGIF, 0},
+		{"image/png, image/gif;q=0.9", "", formatPNG, 0},
+		{"image/*", "", formatGIF, 0},
+		{"text/*", "", formatGCode, 0},
+		{"", "svg", formatSVG, 0},
+		{"image/*", "hpgl", nil, http.StatusNotAcceptable},
+		{"", "jpeg", nil, http.StatusNotAcceptable},
+		{"application/json", "", nil, http.StatusNotAcceptable},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/?format="+tc.format, nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept", tc.accept)
+		}
+		got, err := negotiate(r, figureFormats)
+		if tc.want != nil {
+			if err != nil || got != tc.want {
+				t.Errorf("Accept %q, format %q: got %v, %v, want %s",
+					tc.accept, tc.format, got, err, tc.want.name)
+			}
+			continue
+		}
+		var herr *httpError
+		if !errors.As(err, &herr) || herr.status != tc.status {
+			t.Errorf("Accept %q, format %q: got %v, want status %d",
+				tc.accept, tc.format, err, tc.status)
+		}
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+// Bounds of the gallery.
+const (
+	maxGallery  = 1000 // figures remembered, the least recently rendered are forgotten
+	galleryPage = 24   // figures shown in a page
+	thumbSide   = 160  // of thumbnails, in pixels
+)
+
+var galleryTemplate = template.Must(
+	template.ParseFS(assets, "assets/gallery.html"))
+
+// galleryItem is a figure that has been rendered.
+type galleryItem struct {
+	id      string
+	forms   string // canonical
+	conf    *lissajous.Conf
+	renders int
+	last    time.Time
+}
+
+// renderGallery remembers the figures rendered recently, and how many
+// times they were.  It does not keep renders: thumbnails are small PNG
+// renders of the first frame, kept in the render cache like the rest, and
+// shown while they are still there.
+type renderGallery struct {
+	mu    sync.Mutex
+	items map[string]*galleryItem // by ID
+}
+
+var gallery = &renderGallery{items: make(map[string]*galleryItem)}
+
+// record counts a render of the figure.
+func (g *renderGallery) record(conf *lissajous.Conf) {
+	forms := conf.MarshalQuery()
+	id := base62Hash(forms)[:linkIDLength]
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	item, ok := g.items[id]
+	if ok && item.forms != forms {
+		return // a collision, too unlikely to bother
+	}
+	if !ok {
+		if len(g.items) >= maxGallery {
+			g.forgetOldest()
+		}
+		item = &galleryItem{
+			id:    id,
+			forms: forms,
+			conf:  conf,
+		}
+		g.items[id] = item
+	}
+	item.renders++
+	item.last = time.Now()
+}
+
+// forgetOldest forgets the least recently rendered figure.  The lock has to
+// be held.
+func (g *renderGallery) forgetOldest() {
+	var oldest *galleryItem
+	for _, item := range g.items {
+		if oldest == nil || item.last.Before(oldest.last) {
+			oldest = item
+		}
+	}
+	if oldest != nil {
+		delete(g.items, oldest.id)
+	}
+}
+
+// list returns copies of the figures, the most recently rendered first, or
+// the most rendered first if popular is true.
+func (g *renderGallery) list(popular bool) []galleryItem {
+	g.mu.Lock()
+	items := make([]galleryItem, 0, len(g.items))
+	for _, item := range g.items {
+		items = append(items, *item)
+	}
+	g.mu.Unlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		a, b := &items[i], &items[j]
+		if popular && a.renders != b.renders {
+			return a.renders > b.renders
+		}
+		if !a.last.Equal(b.last) {
+			return a.last.After(b.last)
+		}
+		return a.id < b.id
+	})
+	return items
+}
+
+// thumbnail returns the cached thumbnail of the figure, or nil if it is
+// not in the cache.
+func (g *renderGallery) thumbnail(id string) *cacheEntry {
+	g.mu.Lock()
+	item, ok := g.items[id]
+	g.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return renders.get(thumbKey(item.conf))
+}
+
+// thumbConf returns the figure of the thumbnail of conf: the same figure,
+// no larger than thumbSide.
+func thumbConf(conf *lissajous.Conf) *lissajous.Conf {
+	thumb := conf.Clone()
+	if thumb.Side > thumbSide {
+		thumb.Side = thumbSide
+	}
+	return thumb
+}
+
+func thumbKey(conf *lissajous.Conf) string {
+	return cacheKey(formatPNG, 0, thumbConf(conf))
+}
+
+// cacheThumbnail renders the thumbnail of the figure, unless it is already
+// cached.  Thumbnails are a single small frame, so they do not count in the
+// quota of the client.
+func cacheThumbnail(conf *lissajous.Conf) error {
+	key := thumbKey(conf)
+	if renders.get(key) != nil {
+		return nil
+	}
+	_, err := renderFlight.do(key, func() (*cacheEntry, error) {
+		return renderEntry(formatPNG, 0, thumbConf(conf), key)
+	})
+	return err
+}
+
+// paramRange is a filter of the gallery: the figures with a value of a
+// numeric parameter, in the figure or in any of its layers, within bounds.
+type paramRange struct {
+	param    *lissajous.Param
+	min, max string // as given, empty if unbounded
+	lo, hi   float64
+}
+
+// galleryFilters parses the filters of the request, from forms like
+// cycles.min and freqDiff.max.
+func galleryFilters(q url.Values) ([]paramRange, error) {
+	var filters []paramRange
+	for _, p := range lissajous.Params {
+		if p.Kind != lissajous.KindInt && p.Kind != lissajous.KindFloat {
+			continue
+		}
+		pr := paramRange{
+			param: p,
+			min:   q.Get(p.Name + ".min"),
+			max:   q.Get(p.Name + ".max"),
+			lo:    p.Min,
+			hi:    p.Max,
+		}
+		for _, bound := range []struct {
+			form  string
+			value string
+			dst   *float64
+		}{
+			{p.Name + ".min", pr.min, &pr.lo},
+			{p.Name + ".max", pr.max, &pr.hi},
+		} {
+			if bound.value == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(bound.value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s value, a number was expected but %q was found",
+					bound.form, bound.value)
+			}
+			*bound.dst = v
+		}
+		filters = append(filters, pr)
+	}
+	return filters, nil
+}
+
+// active tells if the filter bounds anything.
+func (pr *paramRange) active() bool {
+	return pr.min != "" || pr.max != ""
+}
+
+func (pr *paramRange) match(conf *lissajous.Conf) bool {
+	layers := []*lissajous.Layer{&conf.Layer}
+	if pr.param.Layer && len(conf.Layers) > 0 {
+		layers = layers[:0]
+		for i := range conf.Layers {
+			layers = append(layers, &conf.Layers[i])
+		}
+	}
+	for _, l := range layers {
+		v, err := strconv.ParseFloat(pr.param.Get(conf, l), 64)
+		if err == nil && pr.lo <= v && v <= pr.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// galleryCard is a figure of a page of the gallery.
+type galleryCard struct {
+	ID         string
+	Summary    string // the parameters that are not the default ones
+	Renders    int
+	Last       string
+	Playground template.URL
+	Thumb      template.URL // empty if no render is cached
+}
+
+type galleryFilter struct {
+	Name, Description string
+	Min, Max          string
+	Step              string
+}
+
+type galleryData struct {
+	Popular    bool
+	Filtered   bool // by any filter
+	Filters    []galleryFilter
+	Cards      []galleryCard
+	Total      int
+	Page       int
+	Pages      int
+	Prev, Next template.URL // empty on the first and last pages
+	Recent     template.URL // the same filters, sorted by recency
+	Popularity template.URL // the same filters, sorted by popularity
+}
+
+// galleryHandler serves the gallery:
+//
+//	GET /gallery       a page of the figures rendered, paginated by the
+//	                   page form, sorted by the sort form, recent or
+//	                   popular, and filtered by forms like cycles.min or
+//	                   freqDiff.max
+//	GET /gallery/{id}  the PNG thumbnail of a figure, from the render cache
+func galleryHandler(w http.ResponseWriter, r *http.Request) {
+	if id, ok := strings.CutPrefix(r.URL.Path, "/gallery/"); ok {
+		entry := gallery.thumbnail(id)
+		if entry == nil {
+			writeError(w, r, withStatus(http.StatusNotFound,
+				fmt.Errorf("no thumbnail of figure %s is cached", id)))
+			return
+		}
+		serveEntry(w, r, formatPNG.mediaType, entry)
+		return
+	}
+
+	q := r.URL.Query()
+	var popular bool
+	switch s := q.Get("sort"); s {
+	case "", "recent":
+	case "popular":
+		popular = true
+	default:
+		writeError(w, r, withStatus(http.StatusBadRequest, fmt.Errorf(
+			"bad sort value, recent or popular was expected but %q was found", s)))
+		return
+	}
+	page := 1
+	if s := q.Get("page"); s != "" {
+		var err error
+		if page, err = strconv.Atoi(s); err != nil || page < 1 {
+			writeError(w, r, withStatus(http.StatusBadRequest, fmt.Errorf(
+				"bad page value, a positive int was expected but %q was found", s)))
+			return
+		}
+	}
+	filters, err := galleryFilters(q)
+	if err != nil {
+		writeError(w, r, withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	var items []galleryItem
+	for _, item := range gallery.list(popular) {
+		matches := true
+		for i := range filters {
+			if filters[i].active() && !filters[i].match(item.conf) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			items = append(items, item)
+		}
+	}
+
+	data := galleryData{
+		Popular: popular,
+		Total:   len(items),
+		Page:    page,
+		Pages:   (len(items) + galleryPage - 1) / galleryPage,
+	}
+	if page > 1 && page > data.Pages {
+		writeError(w, r, withStatus(http.StatusNotFound, fmt.Errorf(
+			"page %d not found, there are %d", page, data.Pages)))
+		return
+	}
+	for _, pr := range filters {
+		data.Filtered = data.Filtered || pr.active()
+		step := "any"
+		if pr.param.Kind == lissajous.KindInt {
+			step = "1"
+		}
+		data.Filters = append(data.Filters, galleryFilter{
+			Name:        pr.param.Name,
+			Description: pr.param.Description,
+			Min:         pr.min,
+			Max:         pr.max,
+			Step:        step,
+		})
+	}
+	link := func(sort string, page int) template.URL {
+		v := make(url.Values)
+		for _, pr := range filters {
+			if pr.min != "" {
+				v.Set(pr.param.Name+".min", pr.min)
+			}
+			if pr.max != "" {
+				v.Set(pr.param.Name+".max", pr.max)
+			}
+		}
+		v.Set("sort", sort)
+		if page > 1 {
+			v.Set("page", strconv.Itoa(page))
+		}
+		return template.URL("/gallery?" + v.Encode())
+	}
+	current := "recent"
+	if popular {
+		current = "popular"
+	}
+	data.Recent, data.Popularity = link("recent", 1), link("popular", 1)
+	if page > 1 {
+		data.Prev = link(current, page-1)
+	}
+	if page < data.Pages {
+		data.Next = link(current, page+1)
+	}
+
+	start := (page - 1) * galleryPage
+	for i := start; i < len(items) && i < start+galleryPage; i++ {
+		item := &items[i]
+		card := galleryCard{
+			ID:         item.id,
+			Summary:    figureSummary(item.conf),
+			Renders:    item.renders,
+			Last:       item.last.UTC().Format("2006-01-02 15:04:05 UTC"),
+			Playground: template.URL("/playground?" + item.forms),
+		}
+		if entry := gallery.thumbnail(item.id); entry != nil {
+			card.Thumb = template.URL("/gallery/" + item.id)
+		}
+		data.Cards = append(data.Cards, card)
+	}
+
+	var buf bytes.Buffer
+	if err := galleryTemplate.Execute(&buf, data); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	writeBody(w, r, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// figureSummary returns the parameters that are not the default ones, in
+// the text form of figures, like "cycles=2 freqDiff=3".
+func figureSummary(conf *lissajous.Conf) string {
+	text, err := conf.MarshalText()
+	if err != nil || len(text) == 0 {
+		return "the default figure"
+	}
+	return string(text)
+}
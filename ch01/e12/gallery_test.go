@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+// TestGalleryThumbnail checks that thumbnails are small PNG renders, served
+// from the cache only.
+func TestGalleryThumbnail(t *testing.T) {
+	oldRenders, oldQueue, oldTimeout, oldGallery := renders, renderQueue, renderTimeout, gallery
+	t.Cleanup(func() { renders, renderQueue, renderTimeout, gallery = oldRenders, oldQueue, oldTimeout, oldGallery })
+	renders = newRenderCache(1<<20, nil)
+	renderQueue = newAdmission(1, 1, time.Second)
+	renderTimeout = time.Minute
+	gallery = &renderGallery{items: make(map[string]*galleryItem)}
+
+	conf := lissajous.DefaultConf()
+	conf.Side, conf.NFrames = 2*thumbSide, 2
+	gallery.record(conf)
+	id := base62Hash(conf.MarshalQuery())[:linkIDLength]
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		galleryHandler(w, httptest.NewRequest(http.MethodGet, "/gallery/"+id, nil))
+		return w
+	}
+	if w := get(); w.Code != http.StatusNotFound {
+		t.Fatalf("before caching: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if err := cacheThumbnail(conf); err != nil {
+		t.Fatal(err)
+	}
+	w := get()
+	if w.Code != http.StatusOK {
+		t.Fatalf("after caching: got status %d: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("content type: got %q, want image/png", got)
+	}
+	img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds().Dx(); got != thumbSide {
+		t.Errorf("side: got %d, want %d", got, thumbSide)
+	}
+	if conf.Side != 2*thumbSide {
+		t.Errorf("the figure was changed: side %d", conf.Side)
+	}
+}
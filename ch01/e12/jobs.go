@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+// maxJobs bounds the jobs kept at the same time, in any state.
+const maxJobs = 1000
+
+// States of jobs.
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+// job is a render that runs in the background, for figures that take too
+// long to wait for them.
+type job struct {
+	id     string
+	client *client // whose quota its render counts in
+	format *format
+	frame  int
+	conf   *lissajous.Conf
+	ctx    context.Context // done once the job is canceled
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	state    string
+	progress float64 // from 0 to 1
+	created  time.Time
+	started  time.Time
+	finished time.Time
+	err      error
+	entry    *cacheEntry // the result, once done
+
+	// guarded by the mutex of the runner
+	kept   int       // bytes of the result counted by the runner
+	keptAt time.Time // when the result was counted
+}
+
+// jobStatus is the JSON description of a job.
+type jobStatus struct {
+	ID       string     `json:"id"`
+	State    string     `json:"state"`
+	Progress float64    `json:"progress"`
+	Format   string     `json:"format"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Expires  time.Time  `json:"expires"`
+	Error    string     `json:"error,omitempty"`
+	Result   string     `json:"result,omitempty"` // URL, once done
+}
+
+func (j *job) status(ttl time.Duration) jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	s := jobStatus{
+		ID:       j.id,
+		State:    j.state,
+		Progress: j.progress,
+		Format:   j.format.name,
+		Created:  j.created,
+		Expires:  j.created.Add(ttl),
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		s.Started = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		s.Finished = &finished
+	}
+	if j.err != nil {
+		s.Error = j.err.Error()
+	}
+	if j.state == jobDone {
+		s.Result = "/jobs/" + j.id + "/result"
+	}
+
+	return s
+}
+
+func (j *job) setProgress(done, total int) {
+	j.mu.Lock()
+	j.progress = float64(done) / float64(total)
+	j.mu.Unlock()
+}
+
+// finish records the result of the job, unless it was canceled already.
+// It tells if it did.
+func (j *job) finish(entry *cacheEntry, err error) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.state == jobCanceled {
+		return false
+	}
+	j.finished = time.Now()
+	j.entry, j.err = entry, err
+	if err != nil {
+		j.state = jobFailed
+		return true
+	}
+	j.state = jobDone
+	j.progress = 1
+	return true
+}
+
+// jobRunner runs jobs on a fixed number of workers, and forgets them once
+// their time to live is over, or before if their results take more than
+// maxBytes, the results that finished first going first.
+type jobRunner struct {
+	queue    chan *job
+	timeout  time.Duration // how long a job can run
+	ttl      time.Duration // how long a job is kept since it is created
+	maxBytes int           // of the results kept
+
+	ctx     context.Context // done once the runner shuts down
+	stop    context.CancelFunc
+	workers sync.WaitGroup
+
+	mu    sync.Mutex
+	jobs  map[string]*job
+	bytes int // of the results kept
+}
+
+func newJobRunner(workers, queued int, timeout, ttl time.Duration, maxBytes int) *jobRunner {
+	ctx, stop := context.WithCancel(context.Background())
+	jr := &jobRunner{
+		queue:    make(chan *job, queued),
+		timeout:  timeout,
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		ctx:      ctx,
+		stop:     stop,
+		jobs:     make(map[string]*job),
+	}
+	jr.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go jr.work()
+	}
+	go jr.expire()
+	return jr
+}
+
+// submit queues a job rendering the figure for the client.
+func (jr *jobRunner) submit(c *client, f *format, frame int, conf *lissajous.Conf) (*job, error) {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	if jr.ctx.Err() != nil {
+		return nil, withStatus(http.StatusServiceUnavailable,
+			fmt.Errorf("shutting down"))
+	}
+	ctx, cancel := context.WithCancel(jr.ctx)
+	j := &job{
+		id:      hex.EncodeToString(b[:]),
+		client:  c,
+		format:  f,
+		frame:   frame,
+		conf:    conf,
+		ctx:     ctx,
+		cancel:  cancel,
+		state:   jobQueued,
+		created: time.Now(),
+	}
+
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+
+	if len(jr.jobs) >= maxJobs {
+		cancel()
+		return nil, &httpError{
+			status:     http.StatusServiceUnavailable,
+			err:        fmt.Errorf("there are too many jobs, try again later"),
+			retryAfter: time.Minute,
+		}
+	}
+	select {
+	case jr.queue <- j:
+	default:
+		cancel()
+		return nil, &httpError{
+			status:     http.StatusTooManyRequests,
+			err:        fmt.Errorf("too many jobs waiting, try again later"),
+			retryAfter: time.Minute,
+		}
+	}
+	jr.jobs[j.id] = j
+
+	return j, nil
+}
+
+func (jr *jobRunner) get(id string) (*job, bool) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	j, ok := jr.jobs[id]
+	return j, ok
+}
+
+// cancel stops the job, if it is not finished yet, and forgets it.
+func (jr *jobRunner) cancel(id string) bool {
+	jr.mu.Lock()
+	j, ok := jr.jobs[id]
+	if ok {
+		jr.forget(j)
+	}
+	jr.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	j.mu.Lock()
+	if j.state == jobQueued || j.state == jobRunning {
+		j.state = jobCanceled
+		j.finished = time.Now()
+	}
+	j.mu.Unlock()
+	j.cancel()
+
+	return true
+}
+
+// forget forgets the job and its result.  The lock has to be held.
+func (jr *jobRunner) forget(j *job) {
+	delete(jr.jobs, j.id)
+	jr.bytes -= j.kept
+	j.kept = 0
+}
+
+// keep counts the result of the job, forgetting the jobs whose results
+// were kept first until they all fit in maxBytes.
+func (jr *jobRunner) keep(j *job, entry *cacheEntry) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+
+	if _, ok := jr.jobs[j.id]; !ok {
+		return // canceled meanwhile
+	}
+	j.kept, j.keptAt = len(entry.body), time.Now()
+	jr.bytes += j.kept
+
+	for jr.bytes > jr.maxBytes {
+		var oldest *job
+		for _, other := range jr.jobs {
+			if other != j && other.kept > 0 &&
+				(oldest == nil || other.keptAt.Before(oldest.keptAt)) {
+				oldest = other
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		jr.forget(oldest)
+	}
+}
+
+// finish records the result of the job, and keeps it if there is room for
+// it.
+func (jr *jobRunner) finish(j *job, entry *cacheEntry, err error) {
+	if err == nil && len(entry.body) > jr.maxBytes {
+		entry, err = nil, fmt.Errorf(
+			"the result has %d bytes, more than the %d kept for all the jobs",
+			len(entry.body), jr.maxBytes)
+	}
+	if j.finish(entry, err) && entry != nil {
+		jr.keep(j, entry)
+	}
+}
+
+func (jr *jobRunner) work() {
+	defer jr.workers.Done()
+	for {
+		select {
+		case j := <-jr.queue:
+			jr.run(j)
+		case <-jr.ctx.Done():
+			return
+		}
+	}
+}
+
+// shutdown cancels the jobs, and waits for the running ones to stop.
+func (jr *jobRunner) shutdown(ctx context.Context) error {
+	jr.stop()
+
+	done := make(chan struct{})
+	go func() {
+		jr.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (jr *jobRunner) run(j *job) {
+	j.mu.Lock()
+	if j.state != jobQueued {
+		j.mu.Unlock()
+		return
+	}
+	j.state = jobRunning
+	j.started = time.Now()
+	j.mu.Unlock()
+
+	key := cacheKey(j.format, j.frame, j.conf)
+	if entry := renders.get(key); entry != nil {
+		jr.finish(j, entry, nil)
+		return
+	}
+	if err := usage.take(j.client, 1); err != nil {
+		jr.finish(j, nil, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(j.ctx, jr.timeout)
+	defer cancel()
+
+	// jobs take turns with the other renders, but unlike requests they
+	// wait for as long as they can run
+	release, err := admit(ctx)
+	for err != nil && ctx.Err() == nil {
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+		}
+		release, err = admit(ctx)
+	}
+	if err != nil {
+		jr.finish(j, nil, jobError(ctx.Err(), jr.timeout))
+		return
+	}
+	defer release()
+
+	ctx = lissajous.WithProgress(ctx, j.setProgress)
+
+	var buf bytes.Buffer
+	start := time.Now()
+	err = j.format.render(ctx, &buf, j.conf, j.frame)
+	renderDuration.observe(j.format.id, time.Since(start).Seconds())
+	if err != nil {
+		jr.finish(j, nil, jobError(err, jr.timeout))
+		return
+	}
+
+	entry := newCacheEntry(buf.Bytes())
+	renders.add(key, entry)
+	jr.finish(j, entry, nil)
+}
+
+// jobError returns the error of a job that failed with err.
+func jobError(err error, timeout time.Duration) error {
+	if err == context.DeadlineExceeded {
+		return fmt.Errorf("the job took longer than %s", timeout)
+	}
+	return err
+}
+
+// expire forgets the jobs past their time to live, canceling them if they
+// are still running.
+func (jr *jobRunner) expire() {
+	for now := range time.Tick(time.Minute) {
+		var expired []string
+		jr.mu.Lock()
+		for id, j := range jr.jobs {
+			if now.Sub(j.created) > jr.ttl {
+				expired = append(expired, id)
+			}
+		}
+		jr.mu.Unlock()
+
+		for _, id := range expired {
+			jr.cancel(id)
+		}
+		if len(expired) > 0 {
+			log.Printf("%d jobs expired", len(expired))
+		}
+	}
+}
+
+var jobs *jobRunner
+
+// jobsHandler submits jobs: POST /jobs takes a figure described like the
+// figures of /, with format and frame forms in the URL, and answers with
+// the status of the new job.
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	conf, err := requestConf(w, r)
+	if err == errHelp {
+		err = withStatus(http.StatusBadRequest, fmt.Errorf(
+			"describe the figure with forms or a JSON body, like for /"))
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	f := formatGIF
+	if name := r.URL.Query().Get("format"); name != "" {
+		if f = lookupFormat(name, figureFormats); f == nil {
+			writeError(w, r, withStatus(http.StatusBadRequest, fmt.Errorf(
+				"bad format value, one of %s was expected but %q was found",
+				formatNames(figureFormats), name)))
+			return
+		}
+	}
+	frame, err := frameForm(r, conf)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := checkLimits(r, conf, renderedFrames(f, conf)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	j, err := jobs.submit(requestClient(r), f, frame, conf)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+j.id)
+	writeJSON(w, r, http.StatusAccepted, j.status(jobs.ttl))
+}
+
+// jobHandler serves a job:
+//
+//	GET /jobs/{id}         its status and progress
+//	GET /jobs/{id}/result  its render, once done
+//	DELETE /jobs/{id}      cancels and forgets it
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	id, result := strings.CutSuffix(id, "/result")
+
+	notFound := withStatus(http.StatusNotFound, fmt.Errorf(
+		"job %s not found, it may have expired, or been forgotten for newer results", id))
+
+	if r.Method == http.MethodDelete {
+		if result {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, r, withStatus(http.StatusMethodNotAllowed, fmt.Errorf(
+				"results are read only, use DELETE /jobs/%s", id)))
+			return
+		}
+		if !jobs.cancel(id) {
+			writeError(w, r, notFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	j, ok := jobs.get(id)
+	if !ok {
+		writeError(w, r, notFound)
+		return
+	}
+	if !result {
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, r, http.StatusOK, j.status(jobs.ttl))
+		return
+	}
+
+	j.mu.Lock()
+	state, entry, err := j.state, j.entry, j.err
+	j.mu.Unlock()
+	switch state {
+	case jobDone:
+		serveEntry(w, r, j.format.mediaType, entry)
+	case jobFailed:
+		writeError(w, r, err)
+	default:
+		writeError(w, r, withStatus(http.StatusConflict, fmt.Errorf(
+			"job %s is %s, its result is not ready", id, state)))
+	}
+}
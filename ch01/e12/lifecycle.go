@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// envPrefix is the prefix of the environment variables that set flags: the
+// flag -max-renders is also set by LISSAJOUS_MAX_RENDERS.
+const envPrefix = "LISSAJOUS_"
+
+// timeouts of the connections to the server.
+type timeouts struct {
+	readHeader time.Duration
+	read       time.Duration
+	write      time.Duration
+	idle       time.Duration
+	shutdown   time.Duration // how long to wait for requests and renders to finish
+}
+
+// draining is set once the server starts shutting down, so it stops being
+// ready.
+var draining atomic.Bool
+
+// shuttingDown is closed once the server starts shutting down, so the
+// responses that never end, like live streams, end.
+var shuttingDown = make(chan struct{})
+
+// envFlagName returns the environment variable that sets the flag.
+func envFlagName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// parseFlags parses the command line flags, taking their defaults from
+// the environment.
+func parseFlags() error {
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envFlagName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		if e := f.Value.Set(v); e != nil {
+			err = fmt.Errorf("bad %s value: %v", envFlagName(f.Name), e)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	flag.Parse()
+	return nil
+}
+
+// listen listens on the address, a TCP address like localhost:8000, or a
+// unix socket like unix:/run/lissajous.sock.  The close function removes
+// the socket file, if any.
+func listen(addr string) (ln net.Listener, close func(), err error) {
+	path := strings.TrimPrefix(addr, "unix:")
+	if path == addr {
+		ln, err = net.Listen("tcp", addr)
+		return ln, func() {}, err
+	}
+
+	// a socket left behind by a crash would make listening fail
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ln, err = net.Listen("unix", path)
+	if err != nil {
+		return nil, nil, err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	return ln, func() { os.Remove(path) }, nil
+}
+
+// serve serves the handler on the address until SIGINT or SIGTERM, and
+// then shuts down gracefully: it stops accepting connections, and waits
+// for the requests and the renders in progress to finish.
+//
+// If tlsConf is not nil, it serves HTTPS, and if redirectAddr is not empty
+// it also redirects plain HTTP requests there to HTTPS.
+func serve(addr string, h http.Handler, t timeouts, tlsConf *tls.Config, redirectAddr string) error {
+	ln, closeListener, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	defer closeListener()
+
+	srv := newServer(h, t)
+	srv.TLSConfig = tlsConf
+	srv.RegisterOnShutdown(func() { close(shuttingDown) })
+
+	ctx, stop := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 2)
+	go func() {
+		if tlsConf != nil {
+			// the certificates are in the configuration already
+			errc <- srv.ServeTLS(ln, "", "")
+			return
+		}
+		errc <- srv.Serve(ln)
+	}()
+	scheme := "http"
+	if tlsConf != nil {
+		scheme = "https"
+	}
+	log.Printf("listening on %s, %s", addr, scheme)
+
+	var redirect *http.Server
+	if redirectAddr != "" {
+		rh, err := redirectToHTTPS(addr)
+		if err != nil {
+			return err
+		}
+		rln, closeRedirectListener, err := listen(redirectAddr)
+		if err != nil {
+			return err
+		}
+		defer closeRedirectListener()
+
+		redirect = newServer(rh, t)
+		go func() {
+			errc <- redirect.Serve(rln)
+		}()
+		log.Printf("redirecting %s to https", redirectAddr)
+	}
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	// a second signal kills the server right away
+	stop()
+
+	log.Print("shutting down")
+	draining.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.shutdown)
+	defer cancel()
+
+	if redirect != nil {
+		if err := redirect.Shutdown(ctx); err != nil {
+			return fmt.Errorf("shutting down: %v", err)
+		}
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("shutting down: %v", err)
+	}
+	// jobs and renders outlive the requests that started them
+	if err := jobs.shutdown(ctx); err != nil {
+		return fmt.Errorf("waiting for jobs: %v", err)
+	}
+	if err := renderQueue.drain(ctx); err != nil {
+		return fmt.Errorf("waiting for renders: %v", err)
+	}
+	log.Print("shut down")
+
+	return nil
+}
+
+func newServer(h http.Handler, t timeouts) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: t.readHeader,
+		ReadTimeout:       t.read,
+		WriteTimeout:      t.write,
+		IdleTimeout:       t.idle,
+	}
+}
+
+// healthz answers whether the server is alive.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeBody(w, r, "text/plain; charset=utf-8", []byte("ok\n"))
+}
+
+// readyz answers whether the server takes requests: it does not while it
+// shuts down, or while its render queue is full.
+func readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	switch {
+	case draining.Load():
+		writeError(w, r, withStatus(http.StatusServiceUnavailable,
+			fmt.Errorf("shutting down")))
+	case renderQueue.full():
+		writeError(w, r, withStatus(http.StatusServiceUnavailable,
+			errQueueFull))
+	default:
+		writeBody(w, r, "text/plain; charset=utf-8", []byte("ready\n"))
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+// limits bound the resources a single render can use.
+type limits struct {
+	maxPixels  int     // pixels of a frame, counting supersampling
+	maxFrames  int     // frames of an animation
+	maxSamples float64 // points drawn in the whole animation
+	maxMemory  float64 // pixels of all the frames, as animations keep them in memory
+}
+
+// renderedFrames returns how many frames of the figure rendering it in f
+// draws: one in still formats, all of them in the rest.
+func renderedFrames(f *format, conf *lissajous.Conf) int {
+	if f.still {
+		return 1
+	}
+	return conf.NFrames
+}
+
+// framePixels returns the pixels of frames frames of the figure, counting
+// supersampling.
+func framePixels(conf *lissajous.Conf, frames int) float64 {
+	n := float64(conf.Side * conf.Supersample)
+	return n * n * float64(frames)
+}
+
+// frameSamples returns how many points are drawn in frames frames of the
+// figure.
+func frameSamples(conf *lissajous.Conf, frames int) float64 {
+	return conf.Samples() / float64(conf.NFrames) * float64(frames)
+}
+
+// check returns an error if drawing frames frames of conf would exceed the
+// limits.
+func (l *limits) check(conf *lissajous.Conf, frames int) error {
+	n := conf.Side * conf.Supersample
+	if pixels := n * n; pixels > l.maxPixels {
+		return fmt.Errorf(
+			"too many pixels per frame, side * supersample = %d squared is %d, the limit is %d",
+			n, pixels, l.maxPixels)
+	}
+
+	if frames > l.maxFrames {
+		return fmt.Errorf("too many frames, %d were requested, the limit is %d",
+			frames, l.maxFrames)
+	}
+
+	if pixels := framePixels(conf, frames); pixels > l.maxMemory {
+		return fmt.Errorf(
+			"too many pixels in all the frames, (side * supersample) squared * nframes is %.0f, "+
+				"the limit is %.0f; lower side, supersample or nframes",
+			pixels, l.maxMemory)
+	}
+
+	if samples := frameSamples(conf, frames); samples > l.maxSamples {
+		return fmt.Errorf(
+			"too many samples, drawing the animation takes %.0f, the limit is %.0f; "+
+				"raise res or lower cycles, nframes, symmetry, mirrors or layers",
+			samples, l.maxSamples)
+	}
+
+	return nil
+}
+
+var (
+	errQueueFull    = errors.New("too many renders waiting, try again later")
+	errQueueTimeout = errors.New("the server is busy, the render waited too long for its turn")
+)
+
+// admission limits how many renders run at the same time, and how many
+// wait for their turn.
+type admission struct {
+	running chan struct{} // a token per running render
+	queued  chan struct{} // a token per running or waiting render
+	wait    time.Duration // how long a render can wait for its turn
+}
+
+func newAdmission(maxRunning, maxWaiting int, wait time.Duration) *admission {
+	return &admission{
+		running: make(chan struct{}, maxRunning),
+		queued:  make(chan struct{}, maxRunning+maxWaiting),
+		wait:    wait,
+	}
+}
+
+// acquire waits for the turn of a render.  On success, release must be
+// called once the render is done.
+func (a *admission) acquire(ctx context.Context) error {
+	select {
+	case a.queued <- struct{}{}:
+	default:
+		return errQueueFull
+	}
+
+	timer := time.NewTimer(a.wait)
+	defer timer.Stop()
+
+	select {
+	case a.running <- struct{}{}:
+		return nil
+	case <-timer.C:
+		<-a.queued
+		return errQueueTimeout
+	case <-ctx.Done():
+		<-a.queued
+		return ctx.Err()
+	}
+}
+
+func (a *admission) release() {
+	<-a.running
+	<-a.queued
+}
+
+// full tells if there is no room for more renders, not even waiting.
+func (a *admission) full() bool {
+	return len(a.queued) == cap(a.queued)
+}
+
+// drain waits until there are no renders running or waiting.
+func (a *admission) drain(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for len(a.queued) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// admit waits for the turn of a render.  If it returns a nil error, the
+// caller has to call the returned release function once the render is
+// done.  Figures are checked against the limits of their clients before.
+func admit(ctx context.Context) (release func(), err error) {
+	if err := renderQueue.acquire(ctx); err != nil {
+		status := http.StatusServiceUnavailable
+		if err == errQueueFull {
+			status = http.StatusTooManyRequests
+		}
+		return nil, &httpError{
+			status:     status,
+			err:        err,
+			retryAfter: renderQueue.wait,
+		}
+	}
+
+	return renderQueue.release, nil
+}
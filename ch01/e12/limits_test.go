@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+func TestLimitsCheck(t *testing.T) {
+	l := limits{maxPixels: 1000 * 1000, maxFrames: 256, maxSamples: 50e6, maxMemory: 100e6}
+	for _, tc := range []struct {
+		name   string
+		f      *format
+		conf   func(c *lissajous.Conf)
+		errors string // a substring of the error, empty if allowed
+	}{
+		{"default", formatGIF, func(c *lissajous.Conf) {}, ""},
+		{"big frames", formatPNG, func(c *lissajous.Conf) { c.Side = 1001 }, "too many pixels per frame"},
+		{"supersampled", formatPNG, func(c *lissajous.Conf) { c.Side, c.Supersample = 600, 2 }, "too many pixels per frame"},
+		{"many frames", formatGIF, func(c *lissajous.Conf) { c.NFrames = 1000 }, "too many frames"},
+		{"many frames of a still", formatPNG, func(c *lissajous.Conf) { c.NFrames = 10000 }, ""},
+		{"memory", formatAPNG, func(c *lissajous.Conf) { c.Side, c.NFrames = 1000, 200 }, "too many pixels in all the frames"},
+		{"memory of a still", formatPNG, func(c *lissajous.Conf) { c.Side, c.NFrames = 1000, 10000 }, ""},
+		{"samples", formatGIF, func(c *lissajous.Conf) { c.Res = 1e-5 }, "too many samples"},
+		{"samples of a still", formatSVG, func(c *lissajous.Conf) { c.Res = 1e-5 }, ""},
+		{"samples of an exposure", formatExposure, func(c *lissajous.Conf) { c.Res = 1e-5 }, "too many samples"},
+	} {
+		conf := lissajous.DefaultConf()
+		tc.conf(conf)
+		err := l.check(conf, renderedFrames(tc.f, conf))
+		switch {
+		case tc.errors == "" && err != nil:
+			t.Errorf("%s: got %v, want no error", tc.name, err)
+		case tc.errors != "" && (err == nil || !strings.Contains(err.Error(), tc.errors)):
+			t.Errorf("%s: got %v, want %q", tc.name, err, tc.errors)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+// Bounds of short links.
+const (
+	maxLinks     = 100000
+	linkIDLength = 8 // base62 digits, more only for colliding figures
+)
+
+var linkID = regexp.MustCompile(`^[0-9A-Za-z]{1,43}$`)
+
+// linkStore maps short IDs to figures, encoded as their canonical forms.
+// IDs are the base62 SHA-256 of the text of the figure, which leaves out
+// the parameters with default values, so a figure always gets the same
+// one, even once new parameters are added.  As links never change, the
+// file is a log of JSON lines, appended to and synced on every new link.
+type linkStore struct {
+	mu    sync.Mutex
+	file  *os.File
+	links map[string]string // forms by ID
+	ids   map[string]string // IDs by text
+}
+
+// linkRecord is a line of the links file.
+type linkRecord struct {
+	ID    string `json:"id"`
+	Forms string `json:"forms"`
+}
+
+func newLinkStore(path string) (*linkStore, error) {
+	s := &linkStore{
+		links: make(map[string]string),
+		ids:   make(map[string]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for n, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var rec linkRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			// the last line is cut if the server crashed while writing it
+			log.Printf("%s:%d: skipping bad link: %v", path, n+1, err)
+			continue
+		}
+		conf, err := linkConf(rec.Forms)
+		if err != nil {
+			// links saved by other versions may not be valid anymore
+			log.Printf("%s:%d: skipping link %s: %v", path, n+1, rec.ID, err)
+			continue
+		}
+		text, err := conf.MarshalText()
+		if err != nil {
+			log.Printf("%s:%d: skipping link %s: %v", path, n+1, rec.ID, err)
+			continue
+		}
+		s.links[rec.ID] = rec.Forms
+		s.ids[string(text)] = rec.ID
+	}
+
+	if s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	// new links start on a line of their own, after a cut one
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		if _, err := s.file.Write([]byte("\n")); err != nil {
+			s.file.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// linkConf decodes the figure of a link.
+func linkConf(forms string) (*lissajous.Conf, error) {
+	conf := new(lissajous.Conf)
+	if err := conf.UnmarshalQuery(forms); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// get returns the figure of the link.
+func (s *linkStore) get(id string) (*lissajous.Conf, bool) {
+	s.mu.Lock()
+	forms, ok := s.links[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	conf, err := linkConf(forms) // checked when stored
+	if err != nil {
+		log.Printf("link %s: %v", id, err)
+		return nil, false
+	}
+	return conf, true
+}
+
+// shorten returns the ID of the figure, storing it if it is new.  It tells
+// if it is.
+func (s *linkStore) shorten(conf *lissajous.Conf) (id string, created bool, err error) {
+	forms := conf.MarshalQuery()
+	b, err := conf.MarshalText()
+	if err != nil {
+		return "", false, err
+	}
+	text := string(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := s.ids[text]; ok {
+		return id, false, nil
+	}
+	if len(s.links) >= maxLinks {
+		return "", false, withStatus(http.StatusInsufficientStorage, fmt.Errorf(
+			"there are already %d links", maxLinks))
+	}
+
+	digits := base62Hash(text)
+	for n := linkIDLength; ; n++ {
+		if n > len(digits) {
+			return "", false, fmt.Errorf("no free ID for link %s", forms)
+		}
+		id = digits[:n]
+		if _, taken := s.links[id]; !taken {
+			break
+		}
+	}
+
+	line, err := json.Marshal(linkRecord{ID: id, Forms: forms})
+	if err != nil {
+		return "", false, err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return "", false, err
+	}
+	if err := s.file.Sync(); err != nil {
+		return "", false, err
+	}
+	s.links[id] = forms
+	s.ids[text] = id
+
+	return id, true, nil
+}
+
+// base62Hash returns the SHA-256 of s in base62, 43 digits at most.
+func base62Hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return new(big.Int).SetBytes(sum[:]).Text(62)
+}
+
+var links *linkStore
+
+// linkInfo describes a short link.
+type linkInfo struct {
+	ID         string `json:"id"`
+	URL        string `json:"url"`        // redirects to the playground
+	Render     string `json:"render"`     // of its render as a GIF
+	Playground string `json:"playground"` // the long URL
+}
+
+func newLinkInfo(id string, conf *lissajous.Conf) linkInfo {
+	return linkInfo{
+		ID:         id,
+		URL:        "/s/" + id,
+		Render:     "/s/" + id + ".gif",
+		Playground: "/playground?" + conf.MarshalQuery(),
+	}
+}
+
+// linksHandler shortens links: POST /s takes a figure described like the
+// figures of /, and answers with its short link, the same for equal
+// figures.
+func linksHandler(w http.ResponseWriter, r *http.Request) {
+	conf, err := presetConf(w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	id, created, err := links.shorten(conf)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	w.Header().Set("Location", "/s/"+id)
+	writeJSON(w, r, status, newLinkInfo(id, conf))
+}
+
+// linkHandler follows short links:
+//
+//	GET /s/{id}          redirects to the playground with the figure
+//	GET /s/{id}.{format} renders the figure, in any format of /
+func linkHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/s/")
+	var f *format
+	if i := strings.LastIndexByte(id, '.'); i >= 0 {
+		ext := id[i+1:]
+		id = id[:i]
+		if f = lookupFormat(ext, figureFormats); f == nil {
+			writeError(w, r, withStatus(http.StatusNotFound, fmt.Errorf(
+				"unknown format %q, use %s", ext, formatNames(figureFormats))))
+			return
+		}
+	}
+
+	var conf *lissajous.Conf
+	ok := linkID.MatchString(id)
+	if ok {
+		conf, ok = links.get(id)
+	}
+	if !ok {
+		writeError(w, r, withStatus(http.StatusNotFound,
+			fmt.Errorf("link %s not found", id)))
+		return
+	}
+
+	if f == nil {
+		http.Redirect(w, r, newLinkInfo(id, conf).Playground, http.StatusFound)
+		return
+	}
+	serveFigure(w, r, f, conf)
+}
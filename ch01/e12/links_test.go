@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+// TestLinkIDs checks that IDs never change: links already given out would
+// point to other figures otherwise.
+func TestLinkIDs(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		want string
+	}{
+		{"", "RZwTDmWjELXeEmMEb0eIIegKayGGUPNsuJweEPhlXi5"},
+		{"cycles=3", "FUTW5ZfbwQdpgLO2eM3rcCBl1BJyVLFQTbyTeU2pOQz"},
+	} {
+		if got := base62Hash(tc.text); got != tc.want {
+			t.Errorf("base62Hash(%q) = %s, want %s", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestLinkStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.jsonl")
+	s, err := newLinkStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	three := lissajous.DefaultConf()
+	three.Cycles = 3
+	for _, step := range []struct {
+		conf    *lissajous.Conf
+		id      string
+		created bool
+	}{
+		{lissajous.DefaultConf(), "RZwTDmWj", true},
+		{three, "FUTW5Zfb", true},
+		{three.Clone(), "FUTW5Zfb", false},
+	} {
+		id, created, err := s.shorten(step.conf)
+		if err != nil || id != step.id || created != step.created {
+			t.Errorf("shorten(%q) = %s, %v, %v, want %s, %v",
+				step.conf.MarshalQuery(), id, created, err, step.id, step.created)
+		}
+	}
+
+	// colliding figures get longer IDs
+	delete(s.ids, "cycles=3")
+	s.links["FUTW5Zfb"] = "taken"
+	if id, _, err := s.shorten(three); err != nil || id != "FUTW5Zfbw" {
+		t.Errorf("shorten of a colliding figure = %s, %v, want FUTW5Zfbw", id, err)
+	}
+	s.file.Close()
+
+	// the links survive the store, even after invalid and cut lines
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":"invalid","forms":"cycles=0"}` + "\n")
+	// as saved by a version with fewer parameters
+	f.WriteString(`{"id":"older","forms":"cycles=5&side=400"}` + "\n")
+	f.WriteString(`{"id":"cut","for`)
+	f.Close()
+
+	again, err := newLinkStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.file.Close()
+	got, ok := again.get("FUTW5Zfb")
+	if !ok || !reflect.DeepEqual(got, three) {
+		t.Errorf("get(FUTW5Zfb) after reopening = %+v, %v, want %+v", got, ok, three)
+	}
+	for _, id := range []string{"invalid", "cut"} {
+		if _, ok := again.get(id); ok {
+			t.Errorf("the %s link was read", id)
+		}
+	}
+	// figures keep their IDs whatever the forms they were saved with
+	five := lissajous.DefaultConf()
+	five.Cycles = 5
+	if id, created, err := again.shorten(five); err != nil || created || id != "older" {
+		t.Errorf("shorten(cycles=5) after reopening = %s, %v, %v, want older, false, nil",
+			id, created, err)
+	}
+	twenty := lissajous.DefaultConf()
+	twenty.Cycles = 20
+	id, _, err := again.shorten(twenty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := newLinkStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer last.file.Close()
+	if _, ok := last.get(id); !ok {
+		t.Error("the link written after the cut line was lost")
+	}
+}
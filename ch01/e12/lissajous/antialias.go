@@ -0,0 +1,118 @@
+package lissajous
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Dithering is the method used to quantize supersampled frames back to the
+// palette. The zero Dithering is DitherNone.
+type Dithering string
+
+const (
+	DitherNone           Dithering = "none"            // nearest palette color
+	DitherOrdered        Dithering = "ordered"         // 4x4 Bayer matrix
+	DitherFloydSteinberg Dithering = "floyd-steinberg" // error diffusion
+)
+
+func (d Dithering) check() error {
+	switch d {
+	case "", DitherNone, DitherOrdered, DitherFloydSteinberg:
+		return nil
+	}
+
+	return fmt.Errorf("unknown dither %q, use %q, %q or %q",
+		d, DitherNone, DitherOrdered, DitherFloydSteinberg)
+}
+
+// downsample averages every n x n block of src into one pixel.
+func downsample(src *image.Paletted, n int) *image.RGBA {
+	side := src.Bounds().Dx() / n
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	area := uint32(n * n)
+
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			var r, g, b uint32
+			for sy := y * n; sy < (y+1)*n; sy++ {
+				for sx := x * n; sx < (x+1)*n; sx++ {
+					sr, sg, sb, _ := src.At(sx, sy).RGBA()
+					r, g, b = r+sr, g+sg, b+sb
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / area >> 8),
+				G: uint8(g / area >> 8),
+				B: uint8(b / area >> 8),
+				A: 0xFF,
+			})
+		}
+	}
+
+	return dst
+}
+
+// quantize converts src back to the palette.
+//
+// Ordered dithering is the default because its fixed pattern keeps flat
+// areas flat, so the background stays a single color and the LZW
+// compression of the GIF is barely affected; error diffusion spreads noise
+// over more pixels and produces bigger files.
+func quantize(src *image.RGBA, dither Dithering) *image.Paletted {
+	dst := image.NewPaletted(src.Bounds(), palette)
+
+	switch dither {
+	case DitherFloydSteinberg:
+		draw.FloydSteinberg.Draw(dst, dst.Bounds(), src, image.Point{})
+	case DitherOrdered:
+		orderedDither(dst, src)
+	default:
+		draw.Draw(dst, dst.Bounds(), src, image.Point{}, draw.Src)
+	}
+
+	return dst
+}
+
+// bayer is the 4x4 threshold map for ordered dithering, with values in
+// [0, 16).
+var bayer = [4][4]int{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// orderedDither quantizes src into dst, biasing each pixel by the Bayer
+// threshold of its position before looking for the nearest palette color.
+// The palette has two levels per channel, so the bias spans the whole
+// channel range.
+func orderedDither(dst *image.Paletted, src *image.RGBA) {
+	b := src.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			bias := (float64(bayer[y%4][x%4])+0.5)/16 - 0.5
+			c := src.RGBAAt(x, y)
+			biased := color.RGBA{
+				R: ditherChannel(c.R, bias),
+				G: ditherChannel(c.G, bias),
+				B: ditherChannel(c.B, bias),
+				A: 0xFF,
+			}
+			dst.SetColorIndex(x, y, uint8(dst.Palette.Index(biased)))
+		}
+	}
+}
+
+func ditherChannel(v uint8, bias float64) uint8 {
+	f := float64(v) + bias*0xFF
+	switch {
+	case f < 0:
+		return 0
+	case f > 0xFF:
+		return 0xFF
+	}
+
+	return uint8(f)
+}
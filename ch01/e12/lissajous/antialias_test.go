@@ -0,0 +1,87 @@
+package lissajous
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// gray returns a side x side image of the gray v.
+func gray(side int, v uint8) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, side, side))
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 0xFF})
+		}
+	}
+	return img
+}
+
+// whites counts the white pixels of img.
+func whites(img *image.Paletted) int {
+	n := 0
+	for _, i := range img.Pix {
+		if palette[i] == (color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDownsample(t *testing.T) {
+	src := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+	// the top left block is half white, the rest black
+	src.SetColorIndex(0, 0, 7)
+	src.SetColorIndex(1, 1, 7)
+
+	dst := downsample(src, 2)
+	if got := dst.Bounds(); got != image.Rect(0, 0, 2, 2) {
+		t.Fatalf("bounds: got %v", got)
+	}
+	for _, tc := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, color.RGBA{0x7F, 0x7F, 0x7F, 0xFF}},
+		{1, 0, color.RGBA{0, 0, 0, 0xFF}},
+		{1, 1, color.RGBA{0, 0, 0, 0xFF}},
+	} {
+		if got := dst.RGBAAt(tc.x, tc.y); got != tc.want {
+			t.Errorf("(%d, %d): got %v, want %v", tc.x, tc.y, got, tc.want)
+		}
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	for _, tc := range []struct {
+		dither Dithering
+		level  uint8
+		want   int // white pixels out of 16x16
+	}{
+		// flat palette colors stay flat
+		{DitherNone, 0x00, 0},
+		{DitherOrdered, 0x00, 0},
+		{DitherFloydSteinberg, 0x00, 0},
+		{DitherOrdered, 0xFF, 256},
+		{DitherFloydSteinberg, 0xFF, 256},
+		// grays are the nearest color without dithering
+		{DitherNone, 0x40, 0},
+		{DitherNone, 0x80, 256},
+		{"", 0x80, 256},
+		// and patterns of the same brightness with ordered dithering
+		{DitherOrdered, 0x40, 64},
+		{DitherOrdered, 0x80, 128},
+		{DitherOrdered, 0xC0, 192},
+	} {
+		got := whites(quantize(gray(16, tc.level), tc.dither))
+		if got != tc.want {
+			t.Errorf("%q dither of %#x: got %d white pixels, want %d",
+				tc.dither, tc.level, got, tc.want)
+		}
+	}
+
+	// error diffusion keeps the brightness too, without a fixed pattern
+	if got := whites(quantize(gray(16, 0x80), DitherFloydSteinberg)); got < 112 || got > 144 {
+		t.Errorf("floyd-steinberg dither of 0x80: got %d white pixels, want about 128", got)
+	}
+}
@@ -0,0 +1,219 @@
+package lissajous
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+)
+
+// PNG writes the given frame of the animation as a PNG image.
+func PNG(out io.Writer, conf *Conf, frame int) error {
+	if err := conf.check(); err != nil {
+		return err
+	}
+	if err := conf.checkFrame(frame); err != nil {
+		return err
+	}
+
+	img, _ := createFrame(conf, frame)
+	return png.Encode(out, img)
+}
+
+// Image draws the given frame of the animation.  Frames past the last one
+// are allowed, they keep advancing the phase, for animations that never
+// end.
+func Image(conf *Conf, frame int) (*image.Paletted, error) {
+	if err := conf.check(); err != nil {
+		return nil, err
+	}
+	if frame < 0 {
+		return nil, fmt.Errorf("bad frame %d, frames cannot be negative", frame)
+	}
+
+	img, _ := createFrame(conf, frame)
+	return img, nil
+}
+
+func (conf *Conf) checkFrame(frame int) error {
+	if frame < 0 || frame >= conf.NFrames {
+		return fmt.Errorf("bad frame %d, the animation has %d frames",
+			frame, conf.NFrames)
+	}
+	return nil
+}
+
+func APNG(out io.Writer, conf *Conf) error {
+	return APNGContext(context.Background(), out, conf)
+}
+
+// APNGContext writes the animation as an animated PNG, that loops forever.
+// It gives up with the error of ctx as soon as it is done, without writing
+// anything to out.
+//
+// Every frame is encoded as a standalone PNG by image/png, and its image
+// data is then moved to the frame control and frame data chunks of the
+// APNG specification.
+func APNGContext(ctx context.Context, out io.Writer, conf *Conf) error {
+	if err := conf.check(); err != nil {
+		return err
+	}
+
+	w := &apngWriter{}
+	err := forEachFrame(ctx, conf, func(frame int) {
+		if w.err != nil {
+			return
+		}
+		img, delay := createFrame(conf, frame)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			w.err = err
+			return
+		}
+		w.addFrame(buf.Bytes(), conf, delay)
+	})
+	if err != nil {
+		return err
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	w.chunk("IEND", nil)
+	_, err = out.Write(w.buf.Bytes())
+	return err
+}
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+type apngWriter struct {
+	buf    bytes.Buffer
+	frames int
+	seq    uint32 // sequence number of the next fcTL or fdAT chunk
+	err    error
+}
+
+// addFrame appends the frame encoded as the PNG in data.
+func (w *apngWriter) addFrame(data []byte, conf *Conf, delay int) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		w.err = err
+		return
+	}
+
+	first := w.frames == 0
+	w.frames++
+	// image/png may split the image data in several IDAT chunks, but the
+	// frame needs a single frame control chunk before them
+	fctlWritten := false
+
+	for _, c := range chunks {
+		switch c.typ {
+		case "IHDR", "PLTE", "tRNS":
+			if !first {
+				continue
+			}
+			w.chunk(c.typ, c.data)
+			if c.typ == "IHDR" {
+				w.actl(conf.NFrames)
+			}
+		case "IDAT":
+			if !fctlWritten {
+				w.fctl(conf.Side, delay)
+				fctlWritten = true
+			}
+			// the image data of the first frame is also the image shown
+			// by decoders that do not know about APNG
+			if first {
+				w.chunk("IDAT", c.data)
+				continue
+			}
+			seq := make([]byte, 4)
+			binary.BigEndian.PutUint32(seq, w.nextSeq())
+			w.chunk("fdAT", append(seq, c.data...))
+		}
+	}
+}
+
+func (w *apngWriter) nextSeq() uint32 {
+	s := w.seq
+	w.seq++
+	return s
+}
+
+func (w *apngWriter) actl(frames int) {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data[0:], uint32(frames))
+	binary.BigEndian.PutUint32(data[4:], 0) // loop forever
+	w.chunk("acTL", data)
+}
+
+// fctl writes the frame control chunk of a frame that covers the whole
+// canvas and is shown for delay hundredths of a second.
+func (w *apngWriter) fctl(side int, delay int) {
+	data := make([]byte, 26)
+	binary.BigEndian.PutUint32(data[0:], w.nextSeq())
+	binary.BigEndian.PutUint32(data[4:], uint32(side))   // width
+	binary.BigEndian.PutUint32(data[8:], uint32(side))   // height
+	binary.BigEndian.PutUint32(data[12:], 0)             // x offset
+	binary.BigEndian.PutUint32(data[16:], 0)             // y offset
+	binary.BigEndian.PutUint16(data[20:], uint16(delay)) // delay numerator
+	binary.BigEndian.PutUint16(data[22:], 100)           // delay denominator
+	data[24] = 0                                         // dispose op: none
+	data[25] = 0                                         // blend op: source
+	w.chunk("fcTL", data)
+}
+
+func (w *apngWriter) chunk(typ string, data []byte) {
+	if w.buf.Len() == 0 {
+		w.buf.WriteString(pngSignature)
+	}
+
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	w.buf.Write(n[:])
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	w.buf.WriteString(typ)
+	w.buf.Write(data)
+	binary.BigEndian.PutUint32(n[:], crc.Sum32())
+	w.buf.Write(n[:])
+}
+
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// pngChunks splits a PNG file in its chunks.
+func pngChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, errors.New("not a PNG file")
+	}
+	data = data[len(pngSignature):]
+
+	var chunks []pngChunk
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		n := binary.BigEndian.Uint32(data)
+		if uint32(len(data)-12) < n {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		chunks = append(chunks, pngChunk{
+			typ:  string(data[4:8]),
+			data: data[8 : 8+n],
+		})
+		data = data[12+n:]
+	}
+
+	return chunks, nil
+}
@@ -0,0 +1,132 @@
+package lissajous
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image/png"
+	"testing"
+)
+
+func TestAPNG(t *testing.T) {
+	conf := DefaultConf()
+	conf.Side, conf.NFrames, conf.Delay = 20, 3, 5
+
+	var buf bytes.Buffer
+	if err := APNG(&buf, conf); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := pngChunks(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var types []string
+	var seqs []uint32
+	for _, c := range chunks {
+		types = append(types, c.typ)
+		switch c.typ {
+		case "acTL":
+			if frames := binary.BigEndian.Uint32(c.data); frames != 3 {
+				t.Errorf("acTL: got %d frames, want 3", frames)
+			}
+		case "fcTL":
+			if delay := binary.BigEndian.Uint16(c.data[20:]); delay != 5 {
+				t.Errorf("fcTL: got a delay of %d, want 5", delay)
+			}
+			seqs = append(seqs, binary.BigEndian.Uint32(c.data))
+		case "fdAT":
+			seqs = append(seqs, binary.BigEndian.Uint32(c.data))
+		}
+	}
+	if types[0] != "IHDR" || types[1] != "acTL" || types[len(types)-1] != "IEND" {
+		t.Errorf("chunks: got %v, want IHDR, acTL first and IEND last", types)
+	}
+	if n := count(types, "fcTL"); n != 3 {
+		t.Errorf("got %d fcTL chunks, want 3", n)
+	}
+	for i, s := range seqs {
+		if s != uint32(i) {
+			t.Fatalf("sequence numbers are not consecutive from 0: %v", seqs)
+		}
+	}
+
+	// decoders that do not know about APNG show the first frame
+	img, err := png.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var first bytes.Buffer
+	if err := PNG(&first, conf, 0); err != nil {
+		t.Fatal(err)
+	}
+	want, err := png.Decode(&first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != want.Bounds() {
+		t.Fatalf("bounds: got %v, want %v", img.Bounds(), want.Bounds())
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if img.At(x, y) != want.At(x, y) {
+				t.Fatalf("(%d, %d) differs from the first frame", x, y)
+			}
+		}
+	}
+}
+
+func TestAPNGCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var buf bytes.Buffer
+	if err := APNGContext(ctx, &buf, DefaultConf()); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes were written", buf.Len())
+	}
+}
+
+func TestPNGFrame(t *testing.T) {
+	conf := DefaultConf()
+	conf.Side, conf.NFrames = 10, 2
+	for _, tc := range []struct {
+		frame int
+		ok    bool
+	}{
+		{0, true}, {1, true}, {2, false}, {-1, false},
+	} {
+		err := PNG(new(bytes.Buffer), conf, tc.frame)
+		if (err == nil) != tc.ok {
+			t.Errorf("frame %d: got %v, want ok %v", tc.frame, err, tc.ok)
+		}
+	}
+}
+
+func TestPNGChunks(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+	}{
+		{"no signature", "GIF89a"},
+		{"short chunk", pngSignature + "\x00\x00\x00"},
+		{"long length", pngSignature + "\x00\x00\x01\x00IDATxxxxcrc!"},
+	} {
+		if _, err := pngChunks([]byte(tc.data)); err == nil {
+			t.Errorf("%s: got no error", tc.name)
+		}
+	}
+}
+
+func count(ss []string, s string) int {
+	n := 0
+	for _, v := range ss {
+		if v == s {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,435 @@
+package lissajous
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// FieldError is an error in the value of a parameter of a figure or of one
+// of its layers.
+type FieldError struct {
+	Layer int    // the index of the layer, -1 for the figure
+	Param string // the name of the parameter, empty for errors of the whole layer
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	if e.Layer < 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("layer%d: %v", e.Layer, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Errors are the errors of several fields.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// err returns e as an error, nil if it is empty.
+func (e Errors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate returns the errors of all the parameters of the figure and of
+// its layers whose values are not the ones Param.Set accepts.
+func (c *Conf) Validate() error {
+	var errs Errors
+	for _, p := range Params {
+		if err := p.Check(c, &c.Layer); err != nil {
+			errs = append(errs, &FieldError{Layer: -1, Param: p.Name, Err: err})
+		}
+		if !p.Layer {
+			continue
+		}
+		for i := range c.Layers {
+			if err := p.Check(c, &c.Layers[i]); err != nil {
+				errs = append(errs, &FieldError{Layer: i, Param: p.Name, Err: err})
+			}
+		}
+	}
+	return errs.err()
+}
+
+// Clone returns a deep copy of the figure.
+func (c *Conf) Clone() *Conf {
+	clone := *c
+	clone.Layer = c.Layer.clone()
+	clone.Layers = nil
+	for i := range c.Layers {
+		clone.Layers = append(clone.Layers, c.Layers[i].clone())
+	}
+	return &clone
+}
+
+func (l *Layer) clone() Layer {
+	c := *l
+	c.Colors = append([]int(nil), l.Colors...)
+	return c
+}
+
+// Values returns the forms that describe the figure, all of them, with
+// layer parameters prefixed by their layer, as in layer1.freqDiff.
+func (c *Conf) Values() url.Values {
+	forms := make(url.Values)
+	for _, p := range Params {
+		forms.Set(p.Name, p.Get(c, &c.Layer))
+		if !p.Layer {
+			continue
+		}
+		for i := range c.Layers {
+			forms.Set(layerForm(i, p.Name), p.Get(c, &c.Layers[i]))
+		}
+	}
+	return forms
+}
+
+// UnmarshalValues sets the figure to the one described by forms like the
+// ones of Values.  Unknown forms are ignored.
+func (c *Conf) UnmarshalValues(forms url.Values) error {
+	*c = *DefaultConf()
+	var errs Errors
+
+	value := func(layer int, name string) (string, bool) {
+		k := name
+		if layer >= 0 {
+			k = layerForm(layer, name)
+		}
+		v, ok := forms[k]
+		if ok && len(v) != 1 {
+			errs = append(errs, &FieldError{Layer: -1, Param: name, Err: fmt.Errorf(
+				"bad number of arguments to %q form: expected 1, found %d", k, len(v))})
+			return "", false
+		}
+		if !ok {
+			return "", false
+		}
+		return v[0], true
+	}
+
+	for _, p := range Params {
+		if v, ok := value(-1, p.Name); ok {
+			if err := p.Set(c, &c.Layer, v); err != nil {
+				errs = append(errs, &FieldError{Layer: -1, Param: p.Name, Err: err})
+			}
+		}
+	}
+
+	n := 0
+	layers := make(map[int]bool)
+	for k := range forms {
+		if i, _, ok := splitLayerForm(k); ok {
+			layers[i] = true
+			if i+1 > n {
+				n = i + 1
+			}
+		}
+	}
+	if len(layers) != n {
+		errs = append(errs, &FieldError{Layer: -1, Err: fmt.Errorf(
+			"bad layer forms, layers have to be numbered from 0 without gaps")})
+		return errs
+	}
+
+	// layers start as a copy of the figure, which has already been fully
+	// parsed, so the forms without a layer prefix act as defaults
+	for i := 0; i < n; i++ {
+		l := c.Layer.clone()
+		for _, p := range Params {
+			v, ok := value(i, p.Name)
+			if !ok {
+				continue
+			}
+			if !p.Layer {
+				errs = append(errs, &FieldError{Layer: i, Param: p.Name, Err: fmt.Errorf(
+					"bad %s form, %s is not a layer parameter", layerForm(i, p.Name), p.Name)})
+				continue
+			}
+			if err := p.Set(c, &l, v); err != nil {
+				errs = append(errs, &FieldError{Layer: i, Param: p.Name, Err: err})
+			}
+		}
+		c.Layers = append(c.Layers, l)
+	}
+
+	return errs.err()
+}
+
+func layerForm(layer int, name string) string {
+	return fmt.Sprintf("layer%d.%s", layer, name)
+}
+
+// splitLayerForm splits forms like "layer2.freqDiff" into the layer index
+// and the form name.
+func splitLayerForm(k string) (int, string, bool) {
+	if !strings.HasPrefix(k, "layer") {
+		return 0, "", false
+	}
+
+	dot := strings.IndexByte(k, '.')
+	if dot == -1 {
+		return 0, "", false
+	}
+
+	i, err := strconv.Atoi(k[len("layer"):dot])
+	if err != nil || i < 0 {
+		return 0, "", false
+	}
+
+	return i, k[dot+1:], true
+}
+
+// MarshalQuery returns the forms of Values as a query string, sorted by
+// name.
+func (c *Conf) MarshalQuery() string {
+	return c.Values().Encode()
+}
+
+// UnmarshalQuery sets the figure to the one described by a query string,
+// like the ones of MarshalQuery.
+func (c *Conf) UnmarshalQuery(query string) error {
+	forms, err := url.ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	return c.UnmarshalValues(forms)
+}
+
+// MarshalJSON encodes the figure as a JSON object with all its parameters,
+// in the order of Params, and its layers, if any, in a layers array.
+func (c *Conf) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, p := range Params {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeJSONParam(&buf, p, c, &c.Layer); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.Layers) > 0 {
+		buf.WriteString(`,"layers":[`)
+		for i := range c.Layers {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('{')
+			first := true
+			for _, p := range Params {
+				if !p.Layer {
+					continue
+				}
+				if !first {
+					buf.WriteByte(',')
+				}
+				first = false
+				if err := writeJSONParam(&buf, p, c, &c.Layers[i]); err != nil {
+					return nil, err
+				}
+			}
+			buf.WriteByte('}')
+		}
+		buf.WriteByte(']')
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// writeJSONParam writes "name":value, with the value formatted as by Get.
+func writeJSONParam(buf *bytes.Buffer, p *Param, conf *Conf, l *Layer) error {
+	v := p.Get(conf, l)
+	if strings.Contains(v, "Inf") || strings.Contains(v, "NaN") {
+		return fmt.Errorf("bad %s value %s, JSON has no infinities nor NaNs", p.Name, v)
+	}
+
+	fmt.Fprintf(buf, "%q:", p.Name)
+	switch p.Kind {
+	case KindInt, KindFloat, KindBool:
+		buf.WriteString(v)
+	case KindInts, KindMatrix:
+		buf.WriteByte('[')
+		buf.WriteString(v)
+		buf.WriteByte(']')
+	default:
+		s, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(s)
+	}
+	return nil
+}
+
+// UnmarshalJSON sets the figure to the one described by a JSON object like
+// the ones of MarshalJSON.  Unknown fields are ignored.
+func (c *Conf) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	var layers []map[string]json.RawMessage
+	if raw, ok := fields["layers"]; ok {
+		if err := json.Unmarshal(raw, &layers); err != nil {
+			return fmt.Errorf("bad layers value, an array of objects was expected: %v", err)
+		}
+	}
+
+	*c = *DefaultConf()
+	var errs Errors
+
+	for _, p := range Params {
+		if raw, ok := fields[p.Name]; ok {
+			if err := setJSONParam(p, c, &c.Layer, raw); err != nil {
+				errs = append(errs, &FieldError{Layer: -1, Param: p.Name, Err: err})
+			}
+		}
+	}
+
+	for i, fields := range layers {
+		l := c.Layer.clone()
+		for _, p := range Params {
+			raw, ok := fields[p.Name]
+			if !ok {
+				continue
+			}
+			if !p.Layer {
+				errs = append(errs, &FieldError{Layer: i, Param: p.Name, Err: fmt.Errorf(
+					"%s is not a layer parameter", p.Name)})
+				continue
+			}
+			if err := setJSONParam(p, c, &l, raw); err != nil {
+				errs = append(errs, &FieldError{Layer: i, Param: p.Name, Err: err})
+			}
+		}
+		c.Layers = append(c.Layers, l)
+	}
+
+	return errs.err()
+}
+
+// setJSONParam sets the parameter to a JSON value, of the type of the
+// values of MarshalJSON.
+func setJSONParam(p *Param, conf *Conf, l *Layer, raw json.RawMessage) error {
+	var s string
+	var err error
+	switch p.Kind {
+	case KindInt, KindFloat:
+		var n json.Number
+		err = json.Unmarshal(raw, &n)
+		s = string(n)
+	case KindBool:
+		var b bool
+		err = json.Unmarshal(raw, &b)
+		s = strconv.FormatBool(b)
+	case KindString:
+		err = json.Unmarshal(raw, &s)
+	case KindInts, KindMatrix:
+		var ns []json.Number
+		err = json.Unmarshal(raw, &ns)
+		parts := make([]string, len(ns))
+		for i, n := range ns {
+			parts[i] = string(n)
+		}
+		s = strings.Join(parts, ",")
+	}
+	if err != nil {
+		return p.errBadValue(string(raw))
+	}
+	return p.Set(conf, l, s)
+}
+
+// MarshalText encodes the figure compactly, as the parameters that are not
+// the default ones, like "cycles=2 freqDiff=3".  Layers follow, separated
+// by semicolons, as the parameters they do not share with the figure, like
+// "cycles=2; freqDiff=3; freqDiff=2 blend=add".  The default figure is the
+// empty text.
+func (c *Conf) MarshalText() ([]byte, error) {
+	def := DefaultConf()
+	var sections []string
+
+	var fields []string
+	for _, p := range Params {
+		if v := p.Get(c, &c.Layer); v != p.Get(def, &def.Layer) {
+			fields = append(fields, p.Name+"="+v)
+		}
+	}
+	sections = append(sections, strings.Join(fields, " "))
+
+	for i := range c.Layers {
+		fields = fields[:0]
+		for _, p := range Params {
+			if !p.Layer {
+				continue
+			}
+			if v := p.Get(c, &c.Layers[i]); v != p.Get(c, &c.Layer) {
+				fields = append(fields, p.Name+"="+v)
+			}
+		}
+		sections = append(sections, strings.Join(fields, " "))
+	}
+
+	return []byte(strings.Join(sections, "; ")), nil
+}
+
+// UnmarshalText sets the figure to the one described by a text like the
+// ones of MarshalText.
+func (c *Conf) UnmarshalText(text []byte) error {
+	*c = *DefaultConf()
+	var errs Errors
+
+	sections := strings.Split(string(text), ";")
+	for i, section := range sections {
+		layer := i - 1
+		l := &c.Layer
+		if layer >= 0 {
+			c.Layers = append(c.Layers, c.Layer.clone())
+			l = &c.Layers[layer]
+		}
+
+		seen := make(map[string]bool)
+		for _, field := range strings.Fields(section) {
+			name, v, ok := strings.Cut(field, "=")
+			p := LookupParam(name)
+			switch {
+			case !ok:
+				errs = append(errs, &FieldError{Layer: layer, Err: fmt.Errorf(
+					"bad field %q, name=value was expected", field)})
+			case p == nil:
+				errs = append(errs, &FieldError{Layer: layer, Param: name, Err: fmt.Errorf(
+					"unknown parameter %q", name)})
+			case layer >= 0 && !p.Layer:
+				errs = append(errs, &FieldError{Layer: layer, Param: name, Err: fmt.Errorf(
+					"%s is not a layer parameter", name)})
+			case seen[name]:
+				errs = append(errs, &FieldError{Layer: layer, Param: name, Err: fmt.Errorf(
+					"%s is given more than once", name)})
+			default:
+				seen[name] = true
+				if err := p.Set(c, l, v); err != nil {
+					errs = append(errs, &FieldError{Layer: layer, Param: name, Err: err})
+				}
+			}
+		}
+	}
+
+	return errs.err()
+}
@@ -0,0 +1,284 @@
+package lissajous
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// encoding is a way of encoding figures, as the round trips test them.
+type encoding struct {
+	name      string
+	marshal   func(c *Conf) (string, error)
+	unmarshal func(c *Conf, s string) error
+}
+
+var encodings = []encoding{
+	{
+		name: "json",
+		marshal: func(c *Conf) (string, error) {
+			b, err := json.Marshal(c)
+			return string(b), err
+		},
+		unmarshal: func(c *Conf, s string) error { return json.Unmarshal([]byte(s), c) },
+	},
+	{
+		name:      "query",
+		marshal:   func(c *Conf) (string, error) { return c.MarshalQuery(), nil },
+		unmarshal: func(c *Conf, s string) error { return c.UnmarshalQuery(s) },
+	},
+	{
+		name: "text",
+		marshal: func(c *Conf) (string, error) {
+			b, err := c.MarshalText()
+			return string(b), err
+		},
+		unmarshal: func(c *Conf, s string) error { return c.UnmarshalText([]byte(s)) },
+	},
+}
+
+// testConfs returns figures covering every kind of parameter, zero values
+// and layers.
+func testConfs() []struct {
+	name string
+	conf *Conf
+} {
+	layered := DefaultConf()
+	layered.Cycles = 7
+	layered.Layers = []Layer{layered.Layer.clone(), layered.Layer.clone(), layered.Layer.clone()}
+	layered.Layers[1].FreqDiff = -0.1
+	layered.Layers[1].Blend = BlendAdd
+	layered.Layers[1].Colors = []int{1, 2, 7}
+	layered.Layers[2].Affine = Matrix{0.5, -1e-9, 2, 1, 0.25, 3}
+	layered.Layers[2].Order = -3
+	layered.Layers[2].MirrorY = true
+
+	zero := DefaultConf()
+	zero.Dither = ""
+	zero.Blend = ""
+	zero.Layers = []Layer{zero.Layer.clone(), zero.Layer.clone()}
+	zero.Layers[1].Blend = BlendXor
+
+	odd := DefaultConf()
+	odd.Side = 1
+	odd.NFrames = 10000
+	odd.Delay = 0
+	odd.Supersample = 8
+	odd.Dither = DitherFloydSteinberg
+	odd.Res = 1e-6
+	odd.PhaseInc = 1.0 / 3
+	odd.FreqDiff = 1000
+	odd.Symmetry = 64
+	odd.MirrorX = true
+	odd.Colors = []int{0}
+
+	return []struct {
+		name string
+		conf *Conf
+	}{
+		{"default", DefaultConf()},
+		{"layers", layered},
+		{"zero dither and blend", zero},
+		{"bounds and fractions", odd},
+	}
+}
+
+// normalized returns the figure with the empty values turned into the ones
+// they mean, as decoding gives them.
+func normalized(c *Conf) *Conf {
+	n := c.Clone()
+	if n.Dither == "" {
+		n.Dither = DitherNone
+	}
+	for _, l := range append([]*Layer{&n.Layer}, n.layers()...) {
+		if l.Blend == "" {
+			l.Blend = BlendOver
+		}
+	}
+	return n
+}
+
+func TestEncodingsRoundTrip(t *testing.T) {
+	for _, enc := range encodings {
+		for _, tc := range testConfs() {
+			t.Run(enc.name+"/"+tc.name, func(t *testing.T) {
+				s, err := enc.marshal(tc.conf)
+				if err != nil {
+					t.Fatalf("marshal: %v", err)
+				}
+
+				got := new(Conf)
+				if err := enc.unmarshal(got, s); err != nil {
+					t.Fatalf("unmarshal %q: %v", s, err)
+				}
+				if want := normalized(tc.conf); !reflect.DeepEqual(got, want) {
+					t.Errorf("unmarshal %q:\ngot  %+v\nwant %+v", s, got, want)
+				}
+
+				again, err := enc.marshal(got)
+				if err != nil {
+					t.Fatalf("marshal again: %v", err)
+				}
+				if again != s {
+					t.Errorf("not canonical:\nfirst  %q\nsecond %q", s, again)
+				}
+			})
+		}
+	}
+}
+
+// TestMarshalJSONNested checks that figures are encoded the same way by
+// themselves and inside other values.
+func TestMarshalJSONNested(t *testing.T) {
+	for _, tc := range testConfs() {
+		t.Run(tc.name, func(t *testing.T) {
+			want, err := tc.conf.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			nested, err := json.Marshal(struct{ Conf *Conf }{tc.conf})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(nested) != `{"Conf":`+string(want)+`}` {
+				t.Errorf("nested:\ngot  %s\nwant {\"Conf\":%s}", nested, want)
+			}
+		})
+	}
+}
+
+func TestMarshalText(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		conf func(c *Conf)
+		want string
+	}{
+		{"default", func(c *Conf) {}, ""},
+		{"figure", func(c *Conf) { c.Cycles, c.FreqDiff = 2, 3 }, "cycles=2 freqDiff=3"},
+		{"zero values", func(c *Conf) { c.Dither, c.Blend = "", "" }, "dither=none"},
+		{
+			"layers",
+			func(c *Conf) {
+				c.Cycles = 3
+				c.Layers = []Layer{c.Layer.clone(), c.Layer.clone()}
+				c.Layers[0].FreqDiff = 2
+				c.Layers[1].FreqDiff, c.Layers[1].Blend = 3, BlendAdd
+			},
+			"cycles=3; freqDiff=2; freqDiff=3 blend=add",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := DefaultConf()
+			tc.conf(c)
+			got, err := c.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		enc  string
+		in   string
+		want []string // substrings of the errors, in order, empty if valid
+	}{
+		{"json bad values", "json", `{"cycles":0,"side":"x"}`,
+			[]string{"bad side value", "bad cycles value"}},
+		{"json zero values", "json", `{"dither":"","blend":"","layers":[{"blend":""}]}`, nil},
+		{"json figure param in layer", "json", `{"layers":[{},{"nframes":3}]}`,
+			[]string{"layer1: nframes is not a layer parameter"}},
+		{"query gap in layers", "query", "layer0.cycles=2&layer2.cycles=3",
+			[]string{"numbered from 0 without gaps"}},
+		{"query repeated form", "query", "cycles=2&cycles=3",
+			[]string{`bad number of arguments to "cycles" form`}},
+		{"query bad layer value", "query", "layer0.res=2&dither=x",
+			[]string{"bad dither value", "layer0: bad res value"}},
+		{"text", "text", "cycles=2 cycles=3 foo=1 bar; nframes=2 res=0",
+			[]string{"cycles is given more than once", `unknown parameter "foo"`,
+				`bad field "bar"`, "layer0: nframes is not a layer parameter",
+				"layer0: bad res value"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var enc encoding
+			for _, e := range encodings {
+				if e.name == tc.enc {
+					enc = e
+				}
+			}
+			err := enc.unmarshal(new(Conf), tc.in)
+			if len(tc.want) == 0 {
+				if err != nil {
+					t.Fatalf("got %v, want no errors", err)
+				}
+				return
+			}
+			var errs Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("got %v, want Errors", err)
+			}
+			if len(errs) != len(tc.want) {
+				t.Fatalf("got %d errors, want %d: %v", len(errs), len(tc.want), err)
+			}
+			for i, want := range tc.want {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("error %d is %q, want it to contain %q", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		conf func(c *Conf)
+		want []string // the errors, empty if valid
+	}{
+		{"default", func(c *Conf) {}, nil},
+		{"zero dither and blend", func(c *Conf) {
+			c.Dither, c.Blend = "", ""
+			c.Layers = []Layer{c.Layer.clone()}
+		}, nil},
+		{"all at once", func(c *Conf) {
+			c.Side, c.Dither = 0, "bayer"
+			c.Layers = []Layer{c.Layer.clone(), c.Layer.clone()}
+			c.Layers[1].Colors = []int{8}
+			c.Layers[1].Blend = "multiply"
+		}, []string{"bad side value", "bad dither value",
+			"layer1: bad colors value", "layer1: bad blend value"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := DefaultConf()
+			tc.conf(c)
+			before := c.Clone()
+
+			err := c.Validate()
+			if !reflect.DeepEqual(c, before) {
+				t.Errorf("Validate changed the figure")
+			}
+			if len(tc.want) == 0 {
+				if err != nil {
+					t.Fatalf("got %v, want no errors", err)
+				}
+				return
+			}
+			var errs Errors
+			if !errors.As(err, &errs) || len(errs) != len(tc.want) {
+				t.Fatalf("got %v, want %d errors", err, len(tc.want))
+			}
+			for i, want := range tc.want {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("error %d is %q, want it to contain %q", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
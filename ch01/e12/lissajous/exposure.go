@@ -0,0 +1,105 @@
+package lissajous
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+// Exposure writes a PNG still that overlays every frame of the animation, as
+// a long exposure photograph of it would: pixels get brighter the more
+// samples land on them. Light only adds up, so the blend of the layers is
+// ignored.
+func Exposure(out io.Writer, conf *Conf) error {
+	return ExposureContext(context.Background(), out, conf)
+}
+
+// ExposureContext is like Exposure, but gives up with the error of ctx as
+// soon as it is done.  Nothing is written to out in that case.
+func ExposureContext(ctx context.Context, out io.Writer, conf *Conf) error {
+	if err := conf.check(); err != nil {
+		return err
+	}
+
+	side := conf.Side
+	acc := newAccumulator(side)
+
+	err := forEachFrame(ctx, conf, func(frame int) {
+		for _, l := range conf.layers() {
+			for _, path := range l.Paths(frame) {
+				for i, p := range path {
+					px, py := cartesianToImage(p.X, p.Y, side)
+					acc.add(px, py, palette[l.colorIndex(i, len(path))])
+				}
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return png.Encode(out, acc.toneMap())
+}
+
+// accumulator sums colors per pixel with far more precision and range than
+// an 8 bit channel can hold.
+type accumulator struct {
+	side int
+	rgb  []float64 // 3 channels per pixel, row major
+}
+
+func newAccumulator(side int) *accumulator {
+	return &accumulator{
+		side: side,
+		rgb:  make([]float64, 3*side*side),
+	}
+}
+
+func (a *accumulator) add(x, y int, c color.Color) {
+	if x < 0 || y < 0 || x >= a.side || y >= a.side {
+		return
+	}
+
+	r, g, b, _ := c.RGBA()
+	i := 3 * (y*a.side + x)
+	a.rgb[i] += float64(r) / 0xFFFF
+	a.rgb[i+1] += float64(g) / 0xFFFF
+	a.rgb[i+2] += float64(b) / 0xFFFF
+}
+
+// toneMap compresses the accumulated values logarithmically, so that both
+// sparse and dense regions of the figure remain visible, and the densest
+// channel ends up at full intensity.
+func (a *accumulator) toneMap() *image.RGBA {
+	var max float64
+	for _, v := range a.rgb {
+		max = math.Max(max, v)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, a.side, a.side))
+	scale := 0.0
+	if max > 0 {
+		scale = 1 / math.Log1p(max)
+	}
+
+	for y := 0; y < a.side; y++ {
+		for x := 0; x < a.side; x++ {
+			i := 3 * (y*a.side + x)
+			img.SetRGBA(x, y, color.RGBA{
+				R: toneMapChannel(a.rgb[i], scale),
+				G: toneMapChannel(a.rgb[i+1], scale),
+				B: toneMapChannel(a.rgb[i+2], scale),
+				A: 0xFF,
+			})
+		}
+	}
+
+	return img
+}
+
+func toneMapChannel(v, scale float64) uint8 {
+	return uint8(math.Round(0xFF * math.Log1p(v) * scale))
+}
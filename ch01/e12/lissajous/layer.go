@@ -0,0 +1,136 @@
+package lissajous
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// Layer is one curve of a figure, with its own oscillators, transforms,
+// colors and blending.
+type Layer struct {
+	Cycles   int     `json:"cycles"`
+	Res      float64 `json:"res"`
+	PhaseInc float64 `json:"phaseInc"`
+	FreqDiff float64 `json:"freqDiff"`
+
+	Symmetry int    `json:"symmetry"`
+	MirrorX  bool   `json:"mirrorX"`
+	MirrorY  bool   `json:"mirrorY"`
+	Affine   Matrix `json:"affine"` // applied to the curve before mirrors and symmetry
+
+	Colors []int    `json:"colors,omitempty"` // palette indexes walked along the curve, all of them if empty
+	Blend  Blending `json:"blend"`
+	Order  int      `json:"order"` // ties are drawn in the order of Conf.Layers
+}
+
+// Paths returns the curve of the layer for the given frame as polylines,
+// sampled every l.Res radians and with the transforms of the layer applied.
+func (l *Layer) Paths(frame int) [][]Point {
+	phase := float64(frame) * l.PhaseInc
+
+	var path []Point
+	for t := 0.0; t < float64(l.Cycles)*2*math.Pi; t += l.Res {
+		x := math.Sin(t)
+		y := math.Sin(t*l.FreqDiff + phase)
+		path = append(path, Point{x, y})
+	}
+
+	return l.transform()([][]Point{path})
+}
+
+// colorIndex returns the palette index for the i-th of n consecutive points
+// of a path, so the colors of the layer are walked once along the path.
+func (l *Layer) colorIndex(i, n int) uint8 {
+	if len(l.Colors) == 0 {
+		return uint8(len(palette) * i / n)
+	}
+
+	return uint8(l.Colors[len(l.Colors)*i/n])
+}
+
+// layers returns the layers of the figure in drawing order.
+func (conf *Conf) layers() []*Layer {
+	if len(conf.Layers) == 0 {
+		return []*Layer{&conf.Layer}
+	}
+
+	result := make([]*Layer, len(conf.Layers))
+	for i := range conf.Layers {
+		result[i] = &conf.Layers[i]
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Order < result[j].Order
+	})
+
+	return result
+}
+
+func (conf *Conf) check() error {
+	if err := conf.Dither.check(); err != nil {
+		return err
+	}
+
+	for _, l := range conf.layers() {
+		if err := l.Blend.check(); err != nil {
+			return err
+		}
+		for _, c := range l.Colors {
+			if c < 0 || c >= len(palette) {
+				return fmt.Errorf("bad color %d, the palette has %d colors",
+					c, len(palette))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Blending is how a layer is combined with the layers drawn before it.
+//
+// The bits of the palette indexes are the blue, green and red channels, so
+// blending indexes bitwise mixes the colors as light would. The zero
+// Blending is BlendOver.
+type Blending string
+
+const (
+	BlendOver  Blending = "over"  // the layer covers what is below
+	BlendUnder Blending = "under" // the layer is only drawn on the background
+	BlendAdd   Blending = "add"   // channels are added, as overlapping lights
+	BlendXor   Blending = "xor"   // channels present in both layers cancel out
+)
+
+func (b Blending) check() error {
+	switch b {
+	case "", BlendOver, BlendUnder, BlendAdd, BlendXor:
+		return nil
+	}
+
+	return fmt.Errorf("unknown blend %q, use %q, %q, %q or %q",
+		b, BlendOver, BlendUnder, BlendAdd, BlendXor)
+}
+
+// draw blends the non background pixels of src onto dst, both of the same
+// size and with the package palette.
+func (b Blending) draw(dst, src *image.Paletted) {
+	for i, s := range src.Pix {
+		if s == backgroundIndex {
+			continue
+		}
+
+		d := dst.Pix[i]
+		switch b {
+		case BlendUnder:
+			if d == backgroundIndex {
+				dst.Pix[i] = s
+			}
+		case BlendAdd:
+			dst.Pix[i] = d | s
+		case BlendXor:
+			dst.Pix[i] = d ^ s
+		default:
+			dst.Pix[i] = s
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package lissajous
 
 import (
+	"context"
 	"image"
 	"image/color"
 	"image/gif"
@@ -29,58 +30,202 @@ const (
 	Delay    = 8     // delay between frames in 10ms units
 	PhaseInc = 0.1   // how much phase to increment in each frame
 	FreqDiff = 2.3   // frequency difference between x and y
+
+	Supersample = 1             // render at this many times the side and downsample
+	Dither      = DitherOrdered // how to quantize supersampled frames
+
+	Symmetry = 1     // copies of the curve rotated around the center
+	MirrorX  = false // add a copy of the figure reflected across the x axis
+	MirrorY  = false // add a copy of the figure reflected across the y axis
+
+	Blend = BlendOver // how a layer is combined with the layers below it
+	Order = 0         // layers are drawn by increasing order
 )
 
+// Conf describes a figure. Its embedded Layer is the only curve of the
+// figure, unless Layers is not empty, in which case the figure is made of
+// those curves instead.
+//
+// A Conf is encoded as JSON, as query strings and as text, always the same
+// way: parameters in a fixed order, and numbers formatted as Param.Get
+// does, so equal figures get equal encodings.  Decoding an encoding gives
+// back the figure, with the empty Dither and Blend turned into the
+// DitherNone and BlendOver they mean.  Decoding starts from DefaultConf,
+// so parameters that are not given get their default values, and layers
+// start as a copy of the parameters of the figure.  The errors of all the
+// fields are reported at once, as Errors.
 type Conf struct {
-	Cycles   int
-	Res      float64
-	Side     int
-	NFrames  int
-	Delay    int
-	PhaseInc float64
-	FreqDiff float64
+	Layer
+
+	Side    int `json:"side"`
+	NFrames int `json:"nframes"`
+	Delay   int `json:"delay"`
+
+	Supersample int       `json:"supersample"`
+	Dither      Dithering `json:"dither"`
+
+	Layers []Layer `json:"layers,omitempty"`
 }
 
 func DefaultConf() *Conf {
 	return &Conf{
-		Cycles:   Cycles,
-		Res:      Res,
-		Side:     Side,
-		NFrames:  NFrames,
-		Delay:    Delay,
-		PhaseInc: PhaseInc,
-		FreqDiff: FreqDiff,
+		Layer: Layer{
+			Cycles:   Cycles,
+			Res:      Res,
+			PhaseInc: PhaseInc,
+			FreqDiff: FreqDiff,
+
+			Symmetry: Symmetry,
+			MirrorX:  MirrorX,
+			MirrorY:  MirrorY,
+			Affine:   Identity,
+
+			Blend: Blend,
+			Order: Order,
+		},
+		Side:    Side,
+		NFrames: NFrames,
+		Delay:   Delay,
+
+		Supersample: Supersample,
+		Dither:      Dither,
 	}
 }
 
 func Gif(out io.Writer, conf *Conf) error {
-	var phase float64
+	return GifContext(context.Background(), out, conf)
+}
+
+// GifContext is like Gif, but gives up with the error of ctx as soon as it
+// is done.  Nothing is written to out in that case.
+func GifContext(ctx context.Context, out io.Writer, conf *Conf) error {
+	if err := conf.check(); err != nil {
+		return err
+	}
+
 	anim := gif.GIF{LoopCount: conf.NFrames}
 
-	for i := 0; i < conf.NFrames; i++ {
-		frame, delay := createFrame(anim, conf, phase)
-		anim.Image = append(anim.Image, frame)
+	err := forEachFrame(ctx, conf, func(frame int) {
+		img, delay := createFrame(conf, frame)
+		anim.Image = append(anim.Image, img)
 		anim.Delay = append(anim.Delay, delay)
-		phase += conf.PhaseInc
+	})
+	if err != nil {
+		return err
 	}
 
 	return gif.EncodeAll(out, &anim)
 }
 
-func createFrame(anim gif.GIF, conf *Conf, phase float64) (*image.Paletted, int) {
-	rect := image.Rect(0, 0, conf.Side, conf.Side)
+// Progress is told how many frames of an animation are done, out of the
+// total.
+type Progress func(done, total int)
+
+type progressKey struct{}
+
+// WithProgress returns a copy of ctx that makes the functions taking it
+// call progress after every frame they draw.
+func WithProgress(ctx context.Context, progress Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, progress)
+}
+
+// forEachFrame calls fn with the number of every animation frame, in order,
+// until ctx is done, and reports the progress to ctx.
+func forEachFrame(ctx context.Context, conf *Conf, fn func(frame int)) error {
+	progress, _ := ctx.Value(progressKey{}).(Progress)
+
+	for i := 0; i < conf.NFrames; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fn(i)
+		if progress != nil {
+			progress(i+1, conf.NFrames)
+		}
+	}
+
+	return nil
+}
+
+// Samples returns how many points are drawn in the whole animation, a
+// measure of the cost of rendering it.
+func (conf *Conf) Samples() float64 {
+	var perFrame float64
+	for _, l := range conf.layers() {
+		copies := math.Max(1, float64(l.Symmetry))
+		if l.MirrorX {
+			copies *= 2
+		}
+		if l.MirrorY {
+			copies *= 2
+		}
+		perFrame += copies * math.Ceil(float64(l.Cycles)*2*math.Pi/l.Res)
+	}
+
+	return perFrame * float64(conf.NFrames)
+}
+
+func createFrame(conf *Conf, frame int) (*image.Paletted, int) {
+	n := conf.Supersample
+	if n <= 1 {
+		return drawFrame(conf, frame, 1), conf.Delay
+	}
+
+	big := drawFrame(conf, frame, n)
+
+	return quantize(downsample(big, n), conf.Dither), conf.Delay
+}
+
+// drawFrame draws the figure on a canvas scale times bigger than conf.Side,
+// with a pen scale pixels wide, so lines end up one pixel wide once
+// downsampled.
+//
+// Every layer is drawn on a canvas of its own and then blended onto the
+// frame, so a layer never blends with itself where it crosses.
+func drawFrame(conf *Conf, frame int, scale int) *image.Paletted {
+	side := conf.Side * scale
+	rect := image.Rect(0, 0, side, side)
 	img := image.NewPaletted(rect, palette)
+	canvas := image.NewPaletted(rect, palette)
+
+	for _, l := range conf.layers() {
+		for i := range canvas.Pix {
+			canvas.Pix[i] = backgroundIndex
+		}
+
+		for _, path := range l.Paths(frame) {
+			for i, p := range path {
+				px, py := cartesianToImage(p.X, p.Y, side)
+				colorIndex := l.colorIndex(i, len(path))
+				for dy := 0; dy < scale; dy++ {
+					for dx := 0; dx < scale; dx++ {
+						canvas.SetColorIndex(px+dx-scale/2, py+dy-scale/2, colorIndex)
+					}
+				}
+			}
+		}
+
+		l.Blend.draw(img, canvas)
+	}
 
-	for t := 0.0; t < float64(conf.Cycles)*2*math.Pi; t += conf.Res {
-		x := math.Sin(t)
-		y := math.Sin(t*conf.FreqDiff + phase)
+	return img
+}
 
-		px, py := cartesianToImage(x, y, conf.Side)
-		colorIndex := colorIndexFromT(t, conf.Cycles)
-		img.SetColorIndex(px, py, colorIndex)
+// Point is a position in the cartesian space of the oscillators, where both
+// coordinates are in [-1, 1].
+type Point struct {
+	X, Y float64
+}
+
+// Paths returns the curves of all the layers of the figure for the given
+// frame as polylines.
+func Paths(conf *Conf, frame int) [][]Point {
+	var paths [][]Point
+	for _, l := range conf.layers() {
+		paths = append(paths, l.Paths(frame)...)
 	}
 
-	return img, conf.Delay
+	return paths
 }
 
 func cartesianToImage(x, y float64, side int) (int, int) {
@@ -89,8 +234,3 @@ func cartesianToImage(x, y float64, side int) (int, int) {
 
 	return int(cX), int(cY)
 }
-
-func colorIndexFromT(t float64, cycles int) uint8 {
-	index := float64(len(palette)) * t / (float64(cycles) * 2 * math.Pi)
-	return uint8(math.Floor(index))
-}
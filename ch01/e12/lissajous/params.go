@@ -0,0 +1,266 @@
+package lissajous
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kind is the type of the value of a parameter.
+type Kind string
+
+const (
+	KindInt    Kind = "int"
+	KindFloat  Kind = "float"
+	KindBool   Kind = "bool"
+	KindString Kind = "string" // one of the Values of the parameter
+	KindInts   Kind = "ints"   // comma separated ints
+	KindMatrix Kind = "matrix" // six comma separated floats, see Matrix
+)
+
+// Param describes a field of Conf or of Layer, and how to read it from and
+// write it to a string.
+type Param struct {
+	Name        string
+	Kind        Kind
+	Layer       bool     // the field belongs to Layer and can be set per layer
+	Min, Max    float64  // inclusive bounds of numbers and of the elements of ints
+	Values      []string // the accepted values of strings, the first one meaning the empty string too
+	Description string
+
+	field func(c *Conf, l *Layer) interface{} // pointer to the field
+}
+
+// Params are all the parameters of a figure, figure parameters first.
+var Params = []*Param{
+	{
+		Name: "side", Kind: KindInt, Min: 1, Max: 10000,
+		Description: "image canvas side in pixels [0..side]",
+		field:       func(c *Conf, _ *Layer) interface{} { return &c.Side },
+	},
+	{
+		Name: "nframes", Kind: KindInt, Min: 1, Max: 10000,
+		Description: "number of animation frames",
+		field:       func(c *Conf, _ *Layer) interface{} { return &c.NFrames },
+	},
+	{
+		Name: "delay", Kind: KindInt, Min: 0, Max: 65535,
+		Description: "delay between frames in 10ms units",
+		field:       func(c *Conf, _ *Layer) interface{} { return &c.Delay },
+	},
+	{
+		Name: "supersample", Kind: KindInt, Min: 1, Max: 8,
+		Description: "render at this many times the side and downsample, for smoother lines",
+		field:       func(c *Conf, _ *Layer) interface{} { return &c.Supersample },
+	},
+	{
+		Name: "dither", Kind: KindString,
+		Values:      []string{string(DitherNone), string(DitherOrdered), string(DitherFloydSteinberg)},
+		Description: "how to quantize supersampled frames",
+		field:       func(c *Conf, _ *Layer) interface{} { return (*string)(&c.Dither) },
+	},
+	{
+		Name: "cycles", Kind: KindInt, Layer: true, Min: 1, Max: 1000,
+		Description: "number of complete x oscillator revolutions",
+		field:       func(_ *Conf, l *Layer) interface{} { return &l.Cycles },
+	},
+	{
+		Name: "res", Kind: KindFloat, Layer: true, Min: 1e-6, Max: 1,
+		Description: "angular resolution",
+		field:       func(_ *Conf, l *Layer) interface{} { return &l.Res },
+	},
+	{
+		Name: "phaseInc", Kind: KindFloat, Layer: true, Min: -100, Max: 100,
+		Description: "how much phase to increment in each frame",
+		field:       func(_ *Conf, l *Layer) interface{} { return &l.PhaseInc },
+	},
+	{
+		Name: "freqDiff", Kind: KindFloat, Layer: true, Min: -1000, Max: 1000,
+		Description: "frequency difference between x and y",
+		field:       func(_ *Conf, l *Layer) interface{} { return &l.FreqDiff },
+	},
+	{
+		Name: "symmetry", Kind: KindInt, Layer: true, Min: 1, Max: 64,
+		Description: "copies of the curve rotated around the center",
+		field:       func(_ *Conf, l *Layer) interface{} { return &l.Symmetry },
+	},
+	{
+		Name: "mirrorX", Kind: KindBool, Layer: true,
+		Description: "add a copy of the figure reflected across the x axis",
+		field:       func(_ *Conf, l *Layer) interface{} { return &l.MirrorX },
+	},
+	{
+		Name: "mirrorY", Kind: KindBool, Layer: true,
+		Description: "add a copy of the figure reflected across the y axis",
+		field:       func(_ *Conf, l *Layer) interface{} { return &l.MirrorY },
+	},
+	{
+		Name: "affine", Kind: KindMatrix, Layer: true,
+		Description: "matrix a,b,c,d,e,f applied to the curve before mirrors and symmetry, mapping (x, y) to (ax+by+e, cx+dy+f)",
+		field:       func(_ *Conf, l *Layer) interface{} { return &l.Affine },
+	},
+	{
+		Name: "colors", Kind: KindInts, Layer: true, Min: 0, Max: 7,
+		Description: "palette indexes walked along the curve, all of them if empty",
+		field:       func(_ *Conf, l *Layer) interface{} { return &l.Colors },
+	},
+	{
+		Name: "blend", Kind: KindString, Layer: true,
+		Values:      []string{string(BlendOver), string(BlendUnder), string(BlendAdd), string(BlendXor)},
+		Description: "how the curve is combined with the layers below it",
+		field:       func(_ *Conf, l *Layer) interface{} { return (*string)(&l.Blend) },
+	},
+	{
+		Name: "order", Kind: KindInt, Layer: true, Min: -1000, Max: 1000,
+		Description: "layers are drawn by increasing order",
+		field:       func(_ *Conf, l *Layer) interface{} { return &l.Order },
+	},
+}
+
+var paramsByName = func() map[string]*Param {
+	m := make(map[string]*Param, len(Params))
+	for _, p := range Params {
+		m[p.Name] = p
+	}
+	return m
+}()
+
+// LookupParam returns the parameter with the given name, or nil if there is
+// none.
+func LookupParam(name string) *Param {
+	return paramsByName[name]
+}
+
+// Default returns the value of the parameter in DefaultConf.
+func (p *Param) Default() string {
+	c := DefaultConf()
+	return p.Get(c, &c.Layer)
+}
+
+// Get returns the value of the parameter, read from conf or from l if it
+// is a layer parameter.
+func (p *Param) Get(conf *Conf, l *Layer) string {
+	switch v := p.field(conf, l).(type) {
+	case *int:
+		return strconv.Itoa(*v)
+	case *float64:
+		return strconv.FormatFloat(*v, 'g', -1, 64)
+	case *bool:
+		return strconv.FormatBool(*v)
+	case *string:
+		if *v == "" {
+			return p.Values[0] // like DitherNone and BlendOver
+		}
+		return *v
+	case *[]int:
+		s := make([]string, len(*v))
+		for i, n := range *v {
+			s[i] = strconv.Itoa(n)
+		}
+		return strings.Join(s, ",")
+	case *Matrix:
+		return v.String()
+	}
+
+	panic("unknown parameter field type")
+}
+
+// Set parses s and, if it is a valid value for the parameter, stores it in
+// conf, or in l if it is a layer parameter.
+func (p *Param) Set(conf *Conf, l *Layer, s string) error {
+	switch v := p.field(conf, l).(type) {
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil || !p.inBounds(float64(n)) {
+			return p.errBadValue(s)
+		}
+		*v = n
+	case *float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || !p.inBounds(f) {
+			return p.errBadValue(s)
+		}
+		*v = f
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return p.errBadValue(s)
+		}
+		*v = b
+	case *string:
+		if s == "" {
+			s = p.Values[0]
+		}
+		if !p.isValue(s) {
+			return p.errBadValue(s)
+		}
+		*v = s
+	case *[]int:
+		var ns []int
+		if s != "" {
+			for _, f := range strings.Split(s, ",") {
+				n, err := strconv.Atoi(strings.TrimSpace(f))
+				if err != nil || !p.inBounds(float64(n)) {
+					return p.errBadValue(s)
+				}
+				ns = append(ns, n)
+			}
+		}
+		*v = ns
+	case *Matrix:
+		m, err := ParseMatrix(s)
+		if err != nil {
+			return p.errBadValue(s)
+		}
+		*v = m
+	}
+
+	return nil
+}
+
+// Check returns an error if the value of the parameter in conf, or in l if
+// it is a layer parameter, is not one Set would accept.  It leaves them
+// untouched.
+func (p *Param) Check(conf *Conf, l *Layer) error {
+	c, cl := *conf, *l
+	return p.Set(&c, &cl, p.Get(conf, l))
+}
+
+func (p *Param) inBounds(f float64) bool {
+	return f >= p.Min && f <= p.Max
+}
+
+func (p *Param) isValue(s string) bool {
+	for _, v := range p.Values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Param) errBadValue(s string) error {
+	return fmt.Errorf("bad %s value, %s was expected but %q was found",
+		p.Name, p.Expected(), s)
+}
+
+// Expected describes the values accepted by the parameter, as in "an int
+// in [1, 64]".
+func (p *Param) Expected() string {
+	switch p.Kind {
+	case KindInt:
+		return fmt.Sprintf("an int in [%g, %g]", p.Min, p.Max)
+	case KindFloat:
+		return fmt.Sprintf("a float in [%g, %g]", p.Min, p.Max)
+	case KindBool:
+		return "a bool"
+	case KindString:
+		return "one of " + strings.Join(p.Values, ", ")
+	case KindInts:
+		return fmt.Sprintf("comma separated ints in [%g, %g]", p.Min, p.Max)
+	case KindMatrix:
+		return "six comma separated floats"
+	}
+
+	return string(p.Kind)
+}
@@ -0,0 +1,214 @@
+package lissajous
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Page is the drawing area of a pen plotter, in millimetres.
+type Page struct {
+	Width  float64
+	Height float64
+	Margin float64 // blank border left on every side of the page
+}
+
+var (
+	A4     = Page{Width: 210, Height: 297, Margin: 10}
+	A3     = Page{Width: 297, Height: 420, Margin: 10}
+	Letter = Page{Width: 215.9, Height: 279.4, Margin: 10}
+)
+
+const (
+	Tolerance = 0.05 // path simplification tolerance in mm
+	Feed      = 3000 // G-code drawing speed in mm/min
+	ZUp       = 5    // G-code pen up height in mm
+	ZDown     = 0    // G-code pen down height in mm
+	hpglUnits = 40   // HPGL plotter units per mm
+)
+
+// Plot configures the export of one frame of a figure as a pen plotter
+// toolpath.
+type Plot struct {
+	Frame     int     // animation frame to draw
+	Page      Page    // the figure is scaled to fit the page, keeping its aspect
+	Tolerance float64 // Ramer-Douglas-Peucker tolerance in mm, 0 disables it
+	Feed      float64 // G-code drawing speed in mm/min
+	ZUp       float64 // G-code pen up height in mm
+	ZDown     float64 // G-code pen down height in mm
+}
+
+func DefaultPlot() *Plot {
+	return &Plot{
+		Page:      A4,
+		Tolerance: Tolerance,
+		Feed:      Feed,
+		ZUp:       ZUp,
+		ZDown:     ZDown,
+	}
+}
+
+// HPGL writes the frame of the figure selected in plot as an HPGL program
+// for pen 1.
+func HPGL(out io.Writer, conf *Conf, plot *Plot) error {
+	paths, err := plotPaths(conf, plot)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(out)
+	fmt.Fprint(w, "IN;SP1;\n")
+	for _, path := range paths {
+		fmt.Fprintf(w, "PU%d,%d;\n", hpgl(path[0].X), hpgl(path[0].Y))
+		fmt.Fprint(w, "PD")
+		for i, p := range path[1:] {
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, "%d,%d", hpgl(p.X), hpgl(p.Y))
+		}
+		fmt.Fprint(w, ";\n")
+	}
+	fmt.Fprint(w, "PU;SP0;\n")
+
+	return w.Flush()
+}
+
+func hpgl(mm float64) int {
+	return int(math.Round(mm * hpglUnits))
+}
+
+// GCode writes the frame of the figure selected in plot as a G-code program
+// that lifts and lowers the pen with the Z axis.
+func GCode(out io.Writer, conf *Conf, plot *Plot) error {
+	paths, err := plotPaths(conf, plot)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(out)
+	fmt.Fprint(w, "G21 ; millimetres\nG90 ; absolute positioning\n")
+	fmt.Fprintf(w, "G0 Z%.3f\n", plot.ZUp)
+	for _, path := range paths {
+		fmt.Fprintf(w, "G0 X%.3f Y%.3f\n", path[0].X, path[0].Y)
+		fmt.Fprintf(w, "G1 Z%.3f F%.0f\n", plot.ZDown, plot.Feed)
+		for _, p := range path[1:] {
+			fmt.Fprintf(w, "G1 X%.3f Y%.3f\n", p.X, p.Y)
+		}
+		fmt.Fprintf(w, "G0 Z%.3f\n", plot.ZUp)
+	}
+	fmt.Fprint(w, "G0 X0 Y0\nM2\n")
+
+	return w.Flush()
+}
+
+// plotPaths returns the paths of the frame in plot, in page millimetres with
+// the origin at the bottom left corner, already simplified.
+func plotPaths(conf *Conf, plot *Plot) ([][]Point, error) {
+	if plot.Frame < 0 || plot.Frame >= conf.NFrames {
+		return nil, fmt.Errorf("bad frame %d, the animation has %d frames",
+			plot.Frame, conf.NFrames)
+	}
+
+	page := plot.Page
+	half := math.Min(page.Width, page.Height)/2 - page.Margin
+	if half <= 0 {
+		return nil, fmt.Errorf("page margins leave no room to draw")
+	}
+
+	var result [][]Point
+	for _, path := range clip(Paths(conf, plot.Frame)) {
+		if len(path) < 2 {
+			continue
+		}
+		mm := make([]Point, len(path))
+		for i, p := range path {
+			mm[i] = Point{
+				X: page.Width/2 + p.X*half,
+				Y: page.Height/2 + p.Y*half,
+			}
+		}
+		result = append(result, simplify(mm, plot.Tolerance))
+	}
+
+	return result, nil
+}
+
+// clip splits the paths where they leave the [-1, 1] square that is shown
+// in the raster images, so transformed figures never draw off the page.
+func clip(paths [][]Point) [][]Point {
+	var result [][]Point
+	for _, path := range paths {
+		start := -1
+		for i, p := range path {
+			inside := math.Abs(p.X) <= 1 && math.Abs(p.Y) <= 1
+			switch {
+			case inside && start == -1:
+				start = i
+			case !inside && start != -1:
+				result = append(result, path[start:i])
+				start = -1
+			}
+		}
+		if start != -1 {
+			result = append(result, path[start:])
+		}
+	}
+
+	return result
+}
+
+// simplify reduces the number of points of a polyline with the
+// Ramer-Douglas-Peucker algorithm, so that no removed point is further than
+// epsilon from the resulting polyline.
+func simplify(path []Point, epsilon float64) []Point {
+	if epsilon <= 0 || len(path) < 3 {
+		return path
+	}
+
+	keep := make([]bool, len(path))
+	keep[0], keep[len(path)-1] = true, true
+
+	// an explicit stack avoids deep recursion on long, smooth paths
+	type span struct{ first, last int }
+	stack := []span{{0, len(path) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		farthest, maxDist := -1, epsilon
+		for i := s.first + 1; i < s.last; i++ {
+			d := distToSegment(path[i], path[s.first], path[s.last])
+			if d > maxDist {
+				farthest, maxDist = i, d
+			}
+		}
+		if farthest != -1 {
+			keep[farthest] = true
+			stack = append(stack, span{s.first, farthest}, span{farthest, s.last})
+		}
+	}
+
+	var result []Point
+	for i, p := range path {
+		if keep[i] {
+			result = append(result, p)
+		}
+	}
+
+	return result
+}
+
+func distToSegment(p, a, b Point) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	lenSq := dx*dx + dy*dy
+	if lenSq == 0 {
+		return math.Hypot(p.X-a.X, p.Y-a.Y)
+	}
+
+	t := ((p.X-a.X)*dx + (p.Y-a.Y)*dy) / lenSq
+	t = math.Max(0, math.Min(1, t))
+
+	return math.Hypot(p.X-(a.X+t*dx), p.Y-(a.Y+t*dy))
+}
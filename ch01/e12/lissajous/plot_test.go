@@ -0,0 +1,88 @@
+package lissajous
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSimplify(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		path    []Point
+		epsilon float64
+		want    []Point
+	}{
+		{"no tolerance", []Point{{0, 0}, {1, 0.1}, {2, 0}}, 0,
+			[]Point{{0, 0}, {1, 0.1}, {2, 0}}},
+		{"too short", []Point{{0, 0}, {1, 1}}, 1, []Point{{0, 0}, {1, 1}}},
+		{"straight line", []Point{{0, 0}, {1, 1}, {2, 2}, {3, 3}}, 0.01,
+			[]Point{{0, 0}, {3, 3}}},
+		{"within tolerance", []Point{{0, 0}, {1, 0.1}, {2, -0.1}, {3, 0}}, 0.2,
+			[]Point{{0, 0}, {3, 0}}},
+		{"corner", []Point{{0, 0}, {1, 0}, {2, 0}, {2, 1}, {2, 2}}, 0.01,
+			[]Point{{0, 0}, {2, 0}, {2, 2}}},
+		{"closed", []Point{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}, 0.5,
+			[]Point{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := simplify(tc.path, tc.epsilon); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestSimplifyTolerance checks that no point of a long curve is further
+// than the tolerance from its simplification.
+func TestSimplifyTolerance(t *testing.T) {
+	const epsilon = 0.001
+	var path []Point
+	for i := 0; i <= 10000; i++ {
+		a := 2 * math.Pi * float64(i) / 10000
+		path = append(path, Point{math.Sin(3 * a), math.Sin(2 * a)})
+	}
+
+	got := simplify(path, epsilon)
+	if len(got) >= len(path)/10 {
+		t.Errorf("%d points were simplified to %d", len(path), len(got))
+	}
+	if got[0] != path[0] || got[len(got)-1] != path[len(path)-1] {
+		t.Errorf("the ends moved")
+	}
+	for _, p := range path {
+		d := math.Inf(1)
+		for i := 1; i < len(got); i++ {
+			d = math.Min(d, distToSegment(p, got[i-1], got[i]))
+		}
+		if d > epsilon {
+			t.Fatalf("%v is %g from the simplified path, more than %g", p, d, epsilon)
+		}
+	}
+}
+
+func TestClip(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		paths [][]Point
+		want  [][]Point
+	}{
+		{"inside", [][]Point{{{0, 0}, {1, 1}, {-1, -1}}},
+			[][]Point{{{0, 0}, {1, 1}, {-1, -1}}}},
+		{"outside", [][]Point{{{2, 0}, {0, 2}}}, nil},
+		{"leaves", [][]Point{{{0, 0}, {0.5, 0}, {1.5, 0}}},
+			[][]Point{{{0, 0}, {0.5, 0}}}},
+		{"enters", [][]Point{{{0, -3}, {0, 0}, {0, 0.5}}},
+			[][]Point{{{0, 0}, {0, 0.5}}}},
+		{"leaves and comes back", [][]Point{{{0, 0}, {0.5, 0}, {0, 1.5}, {-0.5, 0}, {0, 0}}},
+			[][]Point{{{0, 0}, {0.5, 0}}, {{-0.5, 0}, {0, 0}}}},
+		{"several paths", [][]Point{{{0, 0}, {2, 2}}, {{0.1, 0.1}}},
+			[][]Point{{{0, 0}}, {{0.1, 0.1}}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := clip(tc.paths); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package lissajous
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"strings"
+)
+
+// SVG writes the given frame of the animation as an SVG image, with every
+// path as polylines of the colors of its layer.
+//
+// Blending is approximated with CSS blend modes: additive layers are drawn
+// with screen, xor layers with difference, and under layers are drawn
+// below all the others.
+func SVG(out io.Writer, conf *Conf, frame int) error {
+	if err := conf.check(); err != nil {
+		return err
+	}
+	if err := conf.checkFrame(frame); err != nil {
+		return err
+	}
+
+	var over, under []string
+	for _, l := range conf.layers() {
+		group := svgLayer(l, frame, conf.Side)
+		if l.Blend == BlendUnder {
+			// later under layers go below earlier ones
+			under = append([]string{group}, under...)
+			continue
+		}
+		over = append(over, group)
+	}
+
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		conf.Side, conf.Side, conf.Side, conf.Side)
+	fmt.Fprintf(w, `<rect width="100%%" height="100%%" fill="%s"/>`+"\n",
+		svgColor(palette[backgroundIndex]))
+	fmt.Fprint(w, `<g style="isolation: isolate" fill="none" stroke-width="1">`+"\n")
+	for _, g := range append(under, over...) {
+		fmt.Fprint(w, g)
+	}
+	fmt.Fprint(w, "</g>\n</svg>\n")
+
+	return w.Flush()
+}
+
+// svgLayer returns the group with the paths of the layer.  Each path is
+// split in one polyline per run of points of the same color.
+func svgLayer(l *Layer, frame, side int) string {
+	var w strings.Builder
+	switch l.Blend {
+	case BlendAdd:
+		w.WriteString(`<g style="mix-blend-mode: screen">` + "\n")
+	case BlendXor:
+		w.WriteString(`<g style="mix-blend-mode: difference">` + "\n")
+	default:
+		w.WriteString("<g>\n")
+	}
+
+	for _, path := range l.Paths(frame) {
+		n := len(path)
+		start := 0
+		for i := 1; i <= n; i++ {
+			c := l.colorIndex(start, n)
+			if i < n && l.colorIndex(i, n) == c {
+				continue
+			}
+			// every run also takes the first point of the next one, so
+			// the polylines join
+			end := i + 1
+			if end > n {
+				end = n
+			}
+			if c != backgroundIndex {
+				svgPolyline(&w, path[start:end], side, palette[c])
+			}
+			start = i
+		}
+	}
+
+	w.WriteString("</g>\n")
+	return w.String()
+}
+
+func svgPolyline(w *strings.Builder, path []Point, side int, c color.Color) {
+	if len(path) < 2 {
+		return
+	}
+
+	px := make([]Point, len(path))
+	for i, p := range path {
+		px[i] = Point{
+			X: (p.X + 1.0) * float64(side) / 2,
+			Y: (-p.Y + 1.0) * float64(side) / 2,
+		}
+	}
+
+	fmt.Fprintf(w, `<polyline stroke="%s" points="`, svgColor(c))
+	// points closer than a tenth of a pixel to the simplified line would
+	// not change the image, only its size
+	for i, p := range simplify(px, 0.1) {
+		if i > 0 {
+			w.WriteByte(' ')
+		}
+		fmt.Fprintf(w, "%.2f,%.2f", p.X, p.Y)
+	}
+	w.WriteString(`"/>` + "\n")
+}
+
+func svgColor(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+}
@@ -0,0 +1,142 @@
+package lissajous
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Transform maps the paths of a figure to new paths. Transforms are applied
+// to the sampled points, before rasterization.
+type Transform func(paths [][]Point) [][]Point
+
+// Compose returns a transform that applies ts in order.
+func Compose(ts ...Transform) Transform {
+	return func(paths [][]Point) [][]Point {
+		for _, t := range ts {
+			paths = t(paths)
+		}
+		return paths
+	}
+}
+
+// Matrix is a 2D affine transformation {a, b, c, d, e, f}, that maps (x, y)
+// to (a*x + b*y + e, c*x + d*y + f).
+type Matrix [6]float64
+
+var Identity = Matrix{1, 0, 0, 1, 0, 0}
+
+// Rotation returns the matrix of a counterclockwise rotation around the
+// origin.
+func Rotation(angle float64) Matrix {
+	sin, cos := math.Sincos(angle)
+	return Matrix{cos, -sin, sin, cos, 0, 0}
+}
+
+func (m Matrix) apply(p Point) Point {
+	return Point{
+		X: m[0]*p.X + m[1]*p.Y + m[4],
+		Y: m[2]*p.X + m[3]*p.Y + m[5],
+	}
+}
+
+// String returns the six coefficients of m separated by commas, as accepted
+// by ParseMatrix.
+func (m Matrix) String() string {
+	s := make([]string, len(m))
+	for i, v := range m {
+		s[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(s, ",")
+}
+
+// ParseMatrix parses six comma separated coefficients.
+func ParseMatrix(s string) (Matrix, error) {
+	var m Matrix
+	fields := strings.Split(s, ",")
+	if len(fields) != len(m) {
+		return m, fmt.Errorf("a matrix needs %d coefficients, found %d",
+			len(m), len(fields))
+	}
+
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return m, fmt.Errorf("bad matrix coefficient %q", f)
+		}
+		m[i] = v
+	}
+
+	return m, nil
+}
+
+// Affine returns a transform that maps every point by m.
+func Affine(m Matrix) Transform {
+	return func(paths [][]Point) [][]Point {
+		result := make([][]Point, len(paths))
+		for i, path := range paths {
+			result[i] = make([]Point, len(path))
+			for j, p := range path {
+				result[i][j] = m.apply(p)
+			}
+		}
+		return result
+	}
+}
+
+// Rotational returns a transform that gives the figure n-fold rotational
+// symmetry, by adding n-1 copies of it rotated around the origin.
+func Rotational(n int) Transform {
+	return func(paths [][]Point) [][]Point {
+		if n <= 1 {
+			return paths
+		}
+
+		result := paths
+		for i := 1; i < n; i++ {
+			rotate := Affine(Rotation(2 * math.Pi * float64(i) / float64(n)))
+			result = append(result, rotate(paths)...)
+		}
+		return result
+	}
+}
+
+// Mirror returns a transform that adds copies of the figure reflected
+// across the x axis, the y axis or both. Mirroring across both axes adds
+// three copies, like two perpendicular mirrors would.
+func Mirror(x, y bool) Transform {
+	var ts []Transform
+	if x {
+		ts = append(ts, addCopy(Matrix{1, 0, 0, -1, 0, 0}))
+	}
+	if y {
+		ts = append(ts, addCopy(Matrix{-1, 0, 0, 1, 0, 0}))
+	}
+
+	return Compose(ts...)
+}
+
+func addCopy(m Matrix) Transform {
+	return func(paths [][]Point) [][]Point {
+		return append(paths, Affine(m)(paths)...)
+	}
+}
+
+// transform returns the transforms configured in the layer: first the
+// affine matrix, then the mirrors and last the rotational symmetry, so
+// mirrored copies get rotated too, as in a kaleidoscope.
+func (l *Layer) transform() Transform {
+	m := l.Affine
+	// a zero matrix collapses every point to the origin, it is most likely
+	// a Layer built without setting it
+	if m == (Matrix{}) {
+		m = Identity
+	}
+
+	return Compose(
+		Affine(m),
+		Mirror(l.MirrorX, l.MirrorY),
+		Rotational(l.Symmetry),
+	)
+}
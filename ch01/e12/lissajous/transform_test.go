@@ -0,0 +1,101 @@
+package lissajous
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMatrix(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		m    Matrix
+		p    Point
+		want Point
+	}{
+		{"identity", Identity, Point{0.3, -0.7}, Point{0.3, -0.7}},
+		{"translation", Matrix{1, 0, 0, 1, 0.5, -0.25}, Point{1, 1}, Point{1.5, 0.75}},
+		{"shear", Matrix{1, 2, 0, 1, 0, 0}, Point{1, 1}, Point{3, 1}},
+		{"quarter turn", Rotation(math.Pi / 2), Point{1, 0}, Point{0, 1}},
+		{"half turn", Rotation(math.Pi), Point{1, 2}, Point{-1, -2}},
+	} {
+		got := tc.m.apply(tc.p)
+		if math.Abs(got.X-tc.want.X) > 1e-12 || math.Abs(got.Y-tc.want.Y) > 1e-12 {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseMatrix(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want Matrix
+		ok   bool
+	}{
+		{"1,0,0,1,0,0", Identity, true},
+		{" 0.5, -1e-9 ,2,1,0.25,3", Matrix{0.5, -1e-9, 2, 1, 0.25, 3}, true},
+		{"1,0,0,1,0", Matrix{}, false},
+		{"1,0,0,1,0,0,0", Matrix{}, false},
+		{"1,0,0,1,0,x", Matrix{}, false},
+		{"1,0,0,1,0,NaN", Matrix{}, false},
+		{"1,0,0,1,0,Inf", Matrix{}, false},
+		{"", Matrix{}, false},
+	} {
+		got, err := ParseMatrix(tc.s)
+		if (err == nil) != tc.ok || (tc.ok && got != tc.want) {
+			t.Errorf("ParseMatrix(%q) = %v, %v, want %v, ok %v", tc.s, got, err, tc.want, tc.ok)
+		}
+		if tc.ok {
+			if again, err := ParseMatrix(got.String()); err != nil || again != got {
+				t.Errorf("ParseMatrix(%q.String()) = %v, %v", tc.s, again, err)
+			}
+		}
+	}
+}
+
+func TestSymmetries(t *testing.T) {
+	paths := [][]Point{{{1, 0.5}, {0.5, 0.25}}}
+	for _, tc := range []struct {
+		name string
+		t    Transform
+		want [][]Point
+	}{
+		{"no copies", Rotational(1), paths},
+		{"rotational", Rotational(2), [][]Point{
+			{{1, 0.5}, {0.5, 0.25}},
+			{{-1, -0.5}, {-0.5, -0.25}},
+		}},
+		{"no mirrors", Mirror(false, false), paths},
+		{"mirror x", Mirror(true, false), [][]Point{
+			{{1, 0.5}, {0.5, 0.25}},
+			{{1, -0.5}, {0.5, -0.25}},
+		}},
+		{"mirror both", Mirror(true, true), [][]Point{
+			{{1, 0.5}, {0.5, 0.25}},
+			{{1, -0.5}, {0.5, -0.25}},
+			{{-1, 0.5}, {-0.5, 0.25}},
+			{{-1, -0.5}, {-0.5, -0.25}},
+		}},
+	} {
+		got := tc.t(paths)
+		if !closePaths(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func closePaths(a, b [][]Point) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if math.Hypot(a[i][j].X-b[i][j].X, a[i][j].Y-b[i][j].Y) > 1e-12 {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+// Bounds of live streams.
+const (
+	maxLiveStreams = 100
+	maxFPS         = 30
+	liveHeartbeat  = 15 * time.Second // between SSE comments that keep connections open
+	liveIdle       = time.Hour        // streams unwatched and unchanged for longer are dropped
+)
+
+var liveName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// liveStream is a figure animated forever, whose parameters can change
+// while it is watched.
+type liveStream struct {
+	mu       sync.Mutex
+	conf     *lissajous.Conf // never modified, replaced on changes
+	changed  chan struct{}   // closed and replaced on changes
+	watchers int             // requests streaming it now
+	used     time.Time       // when it was last watched or changed
+}
+
+func newLiveStream() *liveStream {
+	return &liveStream{
+		conf:    lissajous.DefaultConf(),
+		changed: make(chan struct{}),
+		used:    time.Now(),
+	}
+}
+
+func (s *liveStream) get() (*lissajous.Conf, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conf, s.changed
+}
+
+func (s *liveStream) set(conf *lissajous.Conf) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conf = conf
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.used = time.Now()
+}
+
+// watch counts a request streaming s until the returned function is
+// called, so s is not dropped meanwhile.
+func (s *liveStream) watch() (done func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchers++
+	s.used = time.Now()
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.watchers--
+		s.used = time.Now()
+	}
+}
+
+func (s *liveStream) idle(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.watchers == 0 && now.Sub(s.used) > liveIdle
+}
+
+// liveStreams are the live streams by name.  The default one always
+// exists, the rest are created by changing them.
+var liveStreams = struct {
+	sync.Mutex
+	m map[string]*liveStream
+}{m: map[string]*liveStream{"default": newLiveStream()}}
+
+// dropIdleLiveStreams forgets, from time to time, the streams nobody has
+// watched or changed for liveIdle, but the default one.
+func dropIdleLiveStreams() {
+	for now := range time.Tick(liveIdle / 2) {
+		liveStreams.Lock()
+		for name, s := range liveStreams.m {
+			if name != "default" && s.idle(now) {
+				delete(liveStreams.m, name)
+			}
+		}
+		liveStreams.Unlock()
+	}
+}
+
+// liveViewers bounds the streams being rendered at the same time, as every
+// viewer renders its own frames.
+var liveViewers chan struct{}
+
+// lookupLiveStream returns the stream of the name form.  Unless create is
+// true, streams that do not exist are not found.
+func lookupLiveStream(r *http.Request, create bool) (*liveStream, error) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "default"
+	}
+	if !liveName.MatchString(name) {
+		return nil, withStatus(http.StatusBadRequest, fmt.Errorf(
+			"bad name value, %s was expected but %q was found", liveName, name))
+	}
+
+	liveStreams.Lock()
+	defer liveStreams.Unlock()
+
+	s, ok := liveStreams.m[name]
+	if ok {
+		return s, nil
+	}
+	if !create {
+		return nil, withStatus(http.StatusNotFound, fmt.Errorf(
+			"live stream %s not found, POST to /live/control to create it", name))
+	}
+	if len(liveStreams.m) >= maxLiveStreams {
+		return nil, withStatus(http.StatusServiceUnavailable, fmt.Errorf(
+			"there are already %d live streams, use one of them", maxLiveStreams))
+	}
+	s = newLiveStream()
+	liveStreams.m[name] = s
+
+	return s, nil
+}
+
+// endless disables the write timeout of the connection, as the response
+// never ends.
+func endless(w http.ResponseWriter) error {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		return err
+	}
+	if _, ok := w.(http.Flusher); !ok {
+		return fmt.Errorf("streaming is not supported")
+	}
+	return nil
+}
+
+// liveStreamHandler streams the frames of a live stream as a
+// multipart/x-mixed-replace response, that browsers show as an animation,
+// advancing the phase in real time.  The forms are name, the stream, type,
+// png or jpeg, and fps, frames per second, by default the ones of the
+// delay of the figure.
+func liveStreamHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := lookupLiveStream(r, false)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q := r.URL.Query()
+
+	mediaType := "image/png"
+	switch t := q.Get("type"); t {
+	case "", "png":
+	case "jpeg":
+		mediaType = "image/jpeg"
+	default:
+		writeError(w, r, withStatus(http.StatusBadRequest, fmt.Errorf(
+			"bad type value, png or jpeg was expected but %q was found", t)))
+		return
+	}
+	var fps float64
+	if v := q.Get("fps"); v != "" {
+		if fps, err = strconv.ParseFloat(v, 64); err != nil || fps <= 0 || fps > maxFPS {
+			writeError(w, r, withStatus(http.StatusBadRequest, fmt.Errorf(
+				"bad fps value, a float in (0, %d] was expected but %q was found",
+				maxFPS, v)))
+			return
+		}
+	}
+
+	select {
+	case liveViewers <- struct{}{}:
+		defer func() { <-liveViewers }()
+	default:
+		writeError(w, r, &httpError{
+			status:     http.StatusServiceUnavailable,
+			err:        fmt.Errorf("too many live viewers, try again later"),
+			retryAfter: time.Minute,
+		})
+		return
+	}
+	if err := endless(w); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	defer s.watch()()
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	start := time.Now()
+	var buf bytes.Buffer
+	for {
+		conf, changed := s.get()
+		// a frame every delay, in hundredths of a second, like GIFs
+		frameTime := time.Duration(conf.Delay) * 10 * time.Millisecond
+		if frameTime <= 0 {
+			frameTime = 10 * time.Millisecond
+		}
+		interval := frameTime
+		if fps > 0 {
+			interval = time.Duration(float64(time.Second) / fps)
+		}
+		if interval < time.Second/maxFPS {
+			interval = time.Second / maxFPS
+		}
+
+		buf.Reset()
+		frame := int(time.Since(start) / frameTime)
+		img, err := lissajous.Image(conf, frame)
+		if err == nil {
+			if mediaType == "image/jpeg" {
+				err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+			} else {
+				err = png.Encode(&buf, img)
+			}
+		}
+		if err != nil {
+			log.Printf("%s live stream: %v", requestID(r), err)
+			return
+		}
+
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":   {mediaType},
+			"Content-Length": {strconv.Itoa(buf.Len())},
+		})
+		if err == nil {
+			_, err = buf.WriteTo(part)
+		}
+		if err != nil {
+			return // the client is gone
+		}
+		w.(http.Flusher).Flush()
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-shuttingDown:
+			timer.Stop()
+			mw.Close()
+			return
+		}
+	}
+}
+
+// liveEventsHandler pushes the parameters of a live stream as server-sent
+// events, a params event with the JSON figure now and after every change.
+func liveEventsHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := lookupLiveStream(r, false)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := endless(w); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer s.watch()()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+
+	for id := 0; ; id++ {
+		conf, changed := s.get()
+		data, err := json.Marshal(conf)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: params\ndata: %s\n\n", id, data); err != nil {
+			return
+		}
+		w.(http.Flusher).Flush()
+
+	wait:
+		for {
+			select {
+			case <-changed:
+				break wait
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": keep alive\n\n"); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			case <-shuttingDown:
+				return
+			}
+		}
+	}
+}
+
+// liveControlHandler shows and changes the parameters of a live stream.
+// GET answers with its JSON figure.  POST with forms changes the given
+// parameters only, while POST with a JSON body replaces the whole figure;
+// it creates the stream if there is none and, with API keys, it needs one.
+func liveControlHandler(w http.ResponseWriter, r *http.Request) {
+	var s *liveStream
+	var err error
+	if r.Method == http.MethodPost {
+		s, err = changeLiveStream(w, r)
+	} else {
+		s, err = lookupLiveStream(r, false)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	conf, _ := s.get()
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusOK, conf)
+}
+
+// changeLiveStream changes the stream as the request asks, creating it
+// only once the request is allowed and its figure is valid.
+func changeLiveStream(w http.ResponseWriter, r *http.Request) (*liveStream, error) {
+	if err := requireKey(w, r, false); err != nil {
+		return nil, err
+	}
+
+	current := lissajous.DefaultConf()
+	if s, err := lookupLiveStream(r, false); err == nil {
+		current, _ = s.get()
+	}
+	conf, err := liveConf(w, r, current)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := lookupLiveStream(r, true)
+	if err != nil {
+		return nil, err
+	}
+	s.set(conf)
+	return s, nil
+}
+
+// liveConf returns the current figure of a stream changed as the request
+// asks.
+func liveConf(w http.ResponseWriter, r *http.Request, current *lissajous.Conf) (*lissajous.Conf, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var conf *lissajous.Conf
+	var err error
+	if mediaType == "application/json" {
+		conf, err = requestConf(w, r)
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, withStatus(http.StatusBadRequest, err)
+		}
+		forms := current.Values()
+		for k, v := range r.PostForm {
+			forms[k] = v
+		}
+		if conf, err = formToConf(forms); err != nil {
+			err = withStatus(http.StatusBadRequest, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// live streams draw a frame at a time
+	if err := checkLimits(r, conf, 1); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLiveStreamCreation checks that only allowed changes create streams.
+func TestLiveStreamCreation(t *testing.T) {
+	oldKeys, oldStreams, oldLimits := apiKeys, liveStreams.m, renderLimits
+	t.Cleanup(func() { apiKeys, liveStreams.m, renderLimits = oldKeys, oldStreams, oldLimits })
+	renderLimits = limits{maxPixels: 1e6, maxFrames: 256, maxSamples: 1e9, maxMemory: 1e9}
+	apiKeys = map[string]*apiKey{hashSecret("secret"): {Name: "test"}}
+	liveStreams.m = map[string]*liveStream{"default": newLiveStream()}
+
+	handlers := map[string]http.Handler{
+		"/live/stream":  authenticate(http.HandlerFunc(liveStreamHandler)),
+		"/live/events":  authenticate(http.HandlerFunc(liveEventsHandler)),
+		"/live/control": authenticate(http.HandlerFunc(liveControlHandler)),
+	}
+	for i, step := range []struct {
+		method, target, key, body string
+		want                      int
+	}{
+		{"GET", "/live/control", "", "", http.StatusOK},
+		{"GET", "/live/control?name=made-up", "", "", http.StatusNotFound},
+		{"GET", "/live/stream?name=made-up", "", "", http.StatusNotFound},
+		{"GET", "/live/events?name=made-up", "", "", http.StatusNotFound},
+		{"POST", "/live/control?name=made-up", "", "cycles=3", http.StatusUnauthorized},
+		{"POST", "/live/control?name=made-up", "secret", "cycles=0", http.StatusBadRequest},
+		{"GET", "/live/control?name=made-up", "", "", http.StatusNotFound},
+		{"POST", "/live/control?name=made-up", "secret", "cycles=3", http.StatusOK},
+		{"GET", "/live/control?name=made-up", "", "", http.StatusOK},
+	} {
+		r := httptest.NewRequest(step.method, step.target, strings.NewReader(step.body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if step.key != "" {
+			r.Header.Set("X-API-Key", step.key)
+		}
+		w := httptest.NewRecorder()
+		handlers[r.URL.Path].ServeHTTP(w, r)
+		if w.Code != step.want {
+			t.Errorf("step %d: %s %s: got %d, want %d: %s",
+				i, step.method, step.target, w.Code, step.want, w.Body)
+		}
+	}
+	if got := len(liveStreams.m); got != 2 {
+		t.Errorf("got %d streams, want 2", got)
+	}
+}
+
+func TestLiveStreamIdle(t *testing.T) {
+	s := newLiveStream()
+	now := time.Now()
+	if s.idle(now) {
+		t.Error("a new stream is idle")
+	}
+	done := s.watch()
+	if s.idle(now.Add(2 * liveIdle)) {
+		t.Error("a watched stream is idle")
+	}
+	done()
+	if !s.idle(now.Add(2 * liveIdle)) {
+		t.Error("an unwatched stream is not idle after liveIdle")
+	}
+}
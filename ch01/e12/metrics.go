@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// counterVec is a Prometheus counter with a label.
+type counterVec struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	values map[string]float64 // by label value
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+	return &counterVec{
+		name: name, help: help, label: label,
+		values: make(map[string]float64),
+	}
+}
+
+func (c *counterVec) add(label string, v float64) {
+	c.mu.Lock()
+	c.values[label] += v
+	c.mu.Unlock()
+}
+
+func (c *counterVec) get(label string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[label]
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, label := range sortedLabels(c.values) {
+		fmt.Fprintf(w, "%s{%s} %s\n",
+			c.name, labelPair(c.label, label), formatFloat(c.values[label]))
+	}
+}
+
+// histogramVec is a Prometheus histogram with a label.
+type histogramVec struct {
+	name, help, label string
+	buckets           []float64 // upper bounds, increasing, without +Inf
+
+	mu     sync.Mutex
+	values map[string]*histogram // by label value
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help, label string, buckets []float64) *histogramVec {
+	return &histogramVec{
+		name: name, help: help, label: label,
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+}
+
+func (h *histogramVec) observe(label string, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, ok := h.values[label]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets)+1)}
+		h.values[label] = hist
+	}
+	hist.counts[sort.SearchFloat64s(h.buckets, v)]++
+	hist.sum += v
+	hist.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	labels := make([]string, 0, len(h.values))
+	for label := range h.values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		hist := h.values[label]
+		pair := labelPair(h.label, label)
+		var cumulative uint64
+		for i, n := range hist.counts {
+			cumulative += n
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			fmt.Fprintf(w, "%s_bucket{%s,%s} %d\n", h.name, pair,
+				labelPair("le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", h.name, pair, formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, pair, hist.count)
+	}
+}
+
+// gauge is a Prometheus gauge whose value is read when the metrics are
+// scraped.
+type gauge struct {
+	name, help string
+	value      func() float64
+}
+
+func (g *gauge) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n",
+		g.name, g.help, g.name, g.name, formatFloat(g.value()))
+}
+
+func sortedLabels(m map[string]float64) []string {
+	labels := make([]string, 0, len(m))
+	for label := range m {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// labelEscaper escapes label values as the Prometheus text format asks.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelPair(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	httpRequests = newCounterVec("http_requests_total",
+		"Requests answered, by status code.", "code")
+	httpResponseBytes = newCounterVec("http_response_bytes_total",
+		"Bytes of the bodies of the responses, by status code.", "code")
+	renderDuration = newHistogramVec("lissajous_render_duration_seconds",
+		"Time taken by renders, by format.", "format",
+		[]float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30})
+	cacheLookups = newCounterVec("lissajous_cache_lookups_total",
+		"Lookups of renders in the cache, by result.", "result")
+)
+
+var gauges = []*gauge{
+	{
+		name: "lissajous_cache_hit_ratio",
+		help: "Fraction of the cache lookups that were hits.",
+		value: func() float64 {
+			hits, misses := cacheLookups.get("hit"), cacheLookups.get("miss")
+			if hits+misses == 0 {
+				return 0
+			}
+			return hits / (hits + misses)
+		},
+	},
+	{
+		name: "lissajous_cache_bytes",
+		help: "Bytes of the renders cached in memory.",
+		value: func() float64 {
+			return float64(renders.size())
+		},
+	},
+	{
+		name: "lissajous_renders_in_flight",
+		help: "Renders running.",
+		value: func() float64 {
+			return float64(len(renderQueue.running))
+		},
+	},
+	{
+		name: "lissajous_renders_waiting",
+		help: "Renders waiting for their turn.",
+		value: func() float64 {
+			return float64(len(renderQueue.queued) - len(renderQueue.running))
+		},
+	},
+}
+
+// metricsHandler serves the metrics in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	httpRequests.write(&buf)
+	httpResponseBytes.write(&buf)
+	renderDuration.write(&buf)
+	cacheLookups.write(&buf)
+	for _, g := range gauges {
+		g.write(&buf)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeBody(w, r, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
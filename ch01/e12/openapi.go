@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+// route is a handler of the server, and its description in /openapi.json.
+type route struct {
+	pattern string   // of the ServeMux
+	methods []string // the allowed ones, any if nil
+	handler http.HandlerFunc
+	api     []apiPath // nothing for undocumented routes
+}
+
+// apiPath describes some methods of a route on a path.  Routes of subtrees
+// have a path for every kind of URL they serve.
+type apiPath struct {
+	path    string   // with {templates}, that have to be among the forms
+	methods []string // the ones of the route if nil
+	summary string
+	figure  bool      // takes a figure, in the forms or in the body of POST and PUT
+	body    string    // media type of the body of POST and PUT, for routes without figures
+	forms   []apiForm // other forms of the URL
+	status  int       // of successful responses, 200 if 0
+	types   []string  // media types of successful responses
+	conf    bool      // successful JSON responses are figures
+}
+
+// apiForm is a form of the URL, or a template of the path.
+type apiForm struct {
+	name        string
+	typ         string // JSON schema type
+	description string
+}
+
+// jsonObject is a JSON object of the OpenAPI document.
+type jsonObject map[string]interface{}
+
+// apiDoc is the OpenAPI document of the server, served by /openapi.json.
+var apiDoc jsonObject
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, apiDoc)
+}
+
+// openAPI returns the OpenAPI 3 document of the routes.  Figures are
+// described from lissajous.Params, the same as formToConf reads them, so
+// the document never tells other parameters than the server takes.  HEAD
+// is left out, as every GET takes it too.
+func openAPI(routes []route) jsonObject {
+	paths := make(jsonObject)
+	for _, rt := range routes {
+		for _, p := range rt.api {
+			item, ok := paths[p.path].(jsonObject)
+			if !ok {
+				item = make(jsonObject)
+				paths[p.path] = item
+			}
+			methods := p.methods
+			if methods == nil {
+				methods = rt.methods
+			}
+			for _, m := range methods {
+				if m != http.MethodHead {
+					item[strings.ToLower(m)] = p.operation(m)
+				}
+			}
+		}
+	}
+
+	return jsonObject{
+		"openapi": "3.0.3",
+		"info": jsonObject{
+			"title":       "lissajous",
+			"version":     "1",
+			"description": "Renders Lissajous figures in several formats.",
+		},
+		"paths": paths,
+		"components": jsonObject{
+			"schemas": jsonObject{
+				"Conf":     confSchema(),
+				"ConfForm": confFormSchema(),
+				"Layer":    layerSchema(),
+				"Problem":  problemSchema,
+			},
+			"securitySchemes": jsonObject{
+				"apiKeyHeader": jsonObject{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"apiKeyQuery":  jsonObject{"type": "apiKey", "in": "query", "name": "api_key"},
+				"bearer":       jsonObject{"type": "http", "scheme": "bearer"},
+			},
+		},
+		// anonymous access is allowed, with lower limits when there are keys
+		"security": []jsonObject{{}, {"apiKeyHeader": []string{}},
+			{"apiKeyQuery": []string{}}, {"bearer": []string{}}},
+	}
+}
+
+var pathTemplate = regexp.MustCompile(`\{([A-Za-z]+)\}`)
+
+func (p *apiPath) operation(method string) jsonObject {
+	op := jsonObject{
+		"summary":     p.summary,
+		"operationId": operationID(method, p.path),
+	}
+
+	inPath := make(map[string]bool)
+	for _, m := range pathTemplate.FindAllStringSubmatch(p.path, -1) {
+		inPath[m[1]] = true
+	}
+	params := []jsonObject{}
+	for _, f := range p.forms {
+		param := jsonObject{
+			"name":        f.name,
+			"in":          "query",
+			"description": f.description,
+			"schema":      jsonObject{"type": f.typ},
+		}
+		if inPath[f.name] {
+			param["in"], param["required"] = "path", true
+		}
+		params = append(params, param)
+	}
+
+	hasBody := method == http.MethodPost || method == http.MethodPut
+	if p.figure && !hasBody {
+		for _, prm := range lissajous.Params {
+			params = append(params, paramQuery(prm))
+		}
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	switch {
+	case hasBody && p.figure:
+		op["requestBody"] = jsonObject{
+			"required":    true,
+			"description": "the figure, as JSON, or as forms like the ones of the URL",
+			"content": jsonObject{
+				"application/json": jsonObject{"schema": schemaRef("Conf")},
+				"application/x-www-form-urlencoded": jsonObject{
+					"schema":   schemaRef("ConfForm"),
+					"encoding": listEncoding(),
+				},
+			},
+		}
+	case hasBody && p.body != "":
+		op["requestBody"] = jsonObject{
+			"required": true,
+			"content":  jsonObject{p.body: jsonObject{}},
+		}
+	}
+
+	status := p.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if method == http.MethodDelete {
+		status = http.StatusNoContent
+	}
+	success := jsonObject{"description": http.StatusText(status)}
+	if status != http.StatusNoContent {
+		content := make(jsonObject)
+		for _, t := range p.types {
+			media := jsonObject{}
+			if t == "application/json" && p.conf {
+				media["schema"] = schemaRef("Conf")
+			}
+			content[t] = media
+		}
+		success["content"] = content
+	}
+	op["responses"] = jsonObject{
+		strconv.Itoa(status): success,
+		"default": jsonObject{
+			"description": "an error",
+			"content": jsonObject{
+				"application/problem+json": jsonObject{"schema": schemaRef("Problem")},
+				"text/plain":               jsonObject{"schema": jsonObject{"type": "string"}},
+			},
+		},
+	}
+
+	return op
+}
+
+// operationID returns an ID like getPresetsNameFormat for GET
+// /presets/{name}.{format}.
+func operationID(method, path string) string {
+	words := strings.FieldsFunc(path, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	})
+	id := strings.ToLower(method)
+	for _, w := range words {
+		id += strings.ToUpper(w[:1]) + w[1:]
+	}
+	return id
+}
+
+func schemaRef(name string) jsonObject {
+	return jsonObject{"$ref": "#/components/schemas/" + name}
+}
+
+// paramSchema returns the JSON schema of the values of a parameter.
+func paramSchema(p *lissajous.Param) jsonObject {
+	s := jsonObject{"description": p.Description}
+	switch p.Kind {
+	case lissajous.KindInt:
+		s["type"], s["minimum"], s["maximum"] = "integer", p.Min, p.Max
+	case lissajous.KindFloat:
+		s["type"], s["minimum"], s["maximum"] = "number", p.Min, p.Max
+	case lissajous.KindBool:
+		s["type"] = "boolean"
+	case lissajous.KindString:
+		s["type"], s["enum"] = "string", p.Values
+	case lissajous.KindInts:
+		s["type"] = "array"
+		s["items"] = jsonObject{"type": "integer", "minimum": p.Min, "maximum": p.Max}
+	case lissajous.KindMatrix:
+		s["type"], s["minItems"], s["maxItems"] = "array", 6, 6
+		s["items"] = jsonObject{"type": "number"}
+	}
+	if d, ok := paramDefaults[p.Name]; ok {
+		s["default"] = d
+	}
+	return s
+}
+
+// paramDefaults are the default values of the parameters, as JSON values,
+// by name.
+var paramDefaults = func() map[string]interface{} {
+	data, err := json.Marshal(lissajous.DefaultConf())
+	if err != nil {
+		panic(err)
+	}
+	var defaults map[string]interface{}
+	if err := json.Unmarshal(data, &defaults); err != nil {
+		panic(err)
+	}
+	return defaults
+}()
+
+// paramQuery returns the description of a parameter as a form of the URL.
+// Lists are comma separated.
+func paramQuery(p *lissajous.Param) jsonObject {
+	q := jsonObject{
+		"name":        p.Name,
+		"in":          "query",
+		"description": p.Description,
+		"schema":      paramSchema(p),
+	}
+	if p.Kind == lissajous.KindInts || p.Kind == lissajous.KindMatrix {
+		q["style"], q["explode"] = "form", false
+	}
+	if p.Layer {
+		q["description"] = p.Description + "; the default of the layers, " +
+			"which take it as layer{i}." + p.Name
+	}
+	return q
+}
+
+// listEncoding tells that the lists of form bodies are comma separated.
+func listEncoding() jsonObject {
+	enc := make(jsonObject)
+	for _, p := range lissajous.Params {
+		if p.Kind == lissajous.KindInts || p.Kind == lissajous.KindMatrix {
+			enc[p.Name] = jsonObject{"style": "form", "explode": false}
+		}
+	}
+	return enc
+}
+
+func confSchema() jsonObject {
+	props := make(jsonObject)
+	for _, p := range lissajous.Params {
+		props[p.Name] = paramSchema(p)
+	}
+	props["layers"] = jsonObject{
+		"type":        "array",
+		"items":       schemaRef("Layer"),
+		"description": "layers drawn over each other, starting as a copy of the layer parameters of the figure",
+	}
+	return jsonObject{"type": "object", "properties": props}
+}
+
+func confFormSchema() jsonObject {
+	props := make(jsonObject)
+	for _, p := range lissajous.Params {
+		props[p.Name] = paramSchema(p)
+	}
+	return jsonObject{
+		"type":       "object",
+		"properties": props,
+		"description": "the parameters of the figure; layers take the layer parameters " +
+			"as layer{i}.{name} forms",
+	}
+}
+
+func layerSchema() jsonObject {
+	props := make(jsonObject)
+	for _, p := range lissajous.Params {
+		if p.Layer {
+			props[p.Name] = paramSchema(p)
+		}
+	}
+	return jsonObject{"type": "object", "properties": props}
+}
+
+// problemSchema describes the problem details of errors.
+var problemSchema = jsonObject{
+	"type": "object",
+	"properties": jsonObject{
+		"type":     jsonObject{"type": "string"},
+		"title":    jsonObject{"type": "string"},
+		"status":   jsonObject{"type": "integer"},
+		"detail":   jsonObject{"type": "string"},
+		"instance": jsonObject{"type": "string"},
+	},
+}
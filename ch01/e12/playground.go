@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+//go:embed assets
+var assets embed.FS
+
+var playground = template.Must(
+	template.ParseFS(assets, "assets/playground.html"))
+
+// assetsHandler serves the static files of the playground.
+func assetsHandler() http.Handler {
+	sub, err := fs.Sub(assets, "assets")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/assets/", http.FileServer(http.FS(sub)))
+}
+
+type preset struct {
+	Name  string
+	Query string
+}
+
+var presets = []preset{
+	{"Big and simple", "cycles=2&freqDiff=1&side=1000"},
+	{"Figure eight", "cycles=2&freqDiff=2"},
+	{"Classic", "cycles=4&freqDiff=2.3&phaseInc=0.1"},
+	{"Smooth", "cycles=2&freqDiff=2&supersample=3"},
+	{"Kaleidoscope", "cycles=1&freqDiff=3&affine=0.5,0,0,0.5,0.4,0&symmetry=6&mirrorX=true"},
+	{"Two layers", "cycles=2&layer0.freqDiff=2&layer0.colors=4&layer1.freqDiff=3&layer1.colors=2&layer1.blend=add"},
+}
+
+// slider is the range of the slider of a numeric parameter, narrower than
+// its bounds when those go far beyond the interesting values.
+type slider struct {
+	Min, Max float64
+}
+
+var sliders = map[string]slider{
+	"side":     {50, 1000},
+	"nframes":  {1, 128},
+	"delay":    {0, 100},
+	"cycles":   {1, 20},
+	"res":      {0.0001, 0.01},
+	"phaseInc": {-1, 1},
+	"freqDiff": {0, 10},
+	"order":    {-10, 10},
+}
+
+// field is a control of the playground.
+type field struct {
+	*lissajous.Param
+	Value  string
+	Slider *slider
+	Step   string
+}
+
+func newField(p *lissajous.Param, conf *lissajous.Conf) field {
+	f := field{
+		Param: p,
+		Value: p.Get(conf, &conf.Layer),
+	}
+
+	switch p.Kind {
+	case lissajous.KindInt:
+		f.Step = "1"
+	case lissajous.KindFloat:
+		f.Step = "any"
+	default:
+		return f
+	}
+
+	s, ok := sliders[p.Name]
+	if !ok {
+		s = slider{p.Min, p.Max}
+	}
+	f.Slider = &s
+
+	return f
+}
+
+type playgroundData struct {
+	Fields      []field
+	LayerFields []field
+	Layers      string       // the layer forms
+	Src         template.URL // the figure with all the forms, for the preview
+	Permalink   template.URL // the playground with the forms that were given
+	Presets     []preset
+}
+
+// servePlayground writes the playground with its controls set to the
+// figure described in forms.
+func servePlayground(w http.ResponseWriter, r *http.Request, forms url.Values) {
+	conf, err := formToConf(forms)
+	if err != nil {
+		conf = lissajous.DefaultConf()
+	}
+
+	data := playgroundData{
+		Presets:   presets,
+		Permalink: template.URL("/playground?" + forms.Encode()),
+	}
+	all := make(url.Values)
+	layers := make(url.Values)
+	for k, v := range forms {
+		if strings.HasPrefix(k, "layer") {
+			layers[k] = v
+			all[k] = v
+		}
+	}
+	for _, p := range lissajous.Params {
+		f := newField(p, conf)
+		data.Fields = append(data.Fields, f)
+		if p.Layer {
+			data.LayerFields = append(data.LayerFields, f)
+		}
+		all.Set(p.Name, f.Value)
+	}
+	data.Layers = layers.Encode()
+	data.Src = template.URL("/?" + all.Encode())
+
+	var buf bytes.Buffer
+	if err := playground.Execute(&buf, data); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeBody(w, r, "text/html; charset=utf-8", buf.Bytes())
+}
+
+func playgroundHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, r, withStatus(http.StatusBadRequest, err))
+		return
+	}
+	servePlayground(w, r, r.Form)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+// maxPresets bounds the presets that can be saved.
+const maxPresets = 10000
+
+var presetName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var errPresetExists = errors.New("preset exists")
+
+// presetStore keeps named figures in a JSON file, an object from names to
+// figures.  The file is replaced atomically on every change, so a crash
+// leaves either the old presets or the new ones.
+type presetStore struct {
+	mu      sync.Mutex
+	path    string
+	presets map[string]*lissajous.Conf
+}
+
+func newPresetStore(path string) (*presetStore, error) {
+	s := &presetStore{
+		path:    path,
+		presets: make(map[string]*lissajous.Conf),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for name, conf := range raw {
+		if s.presets[name], err = jsonToConf(bytes.NewReader(conf)); err != nil {
+			return nil, fmt.Errorf("%s: preset %s: %v", path, name, err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *presetStore) get(name string) (*lissajous.Conf, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conf, ok := s.presets[name]
+	return conf, ok
+}
+
+// names returns the names of the presets, sorted.
+func (s *presetStore) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.presets))
+	for name := range s.presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// put saves the preset.  If replace is false, it fails with
+// errPresetExists for names already taken.  It tells if the preset is new.
+func (s *presetStore) put(name string, conf *lissajous.Conf, replace bool) (created bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, exists := s.presets[name]
+	if exists && !replace {
+		return false, errPresetExists
+	}
+	if !exists && len(s.presets) >= maxPresets {
+		return false, withStatus(http.StatusInsufficientStorage, fmt.Errorf(
+			"there are already %d presets, delete some first", maxPresets))
+	}
+
+	s.presets[name] = conf
+	if err := s.save(); err != nil {
+		if exists {
+			s.presets[name] = old
+		} else {
+			delete(s.presets, name)
+		}
+		return false, err
+	}
+
+	return !exists, nil
+}
+
+// remove deletes the preset, and tells if there was one.
+func (s *presetStore) remove(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, exists := s.presets[name]
+	if !exists {
+		return false, nil
+	}
+
+	delete(s.presets, name)
+	if err := s.save(); err != nil {
+		s.presets[name] = old
+		return false, err
+	}
+
+	return true, nil
+}
+
+// save writes the presets to a temporary file, syncs it and renames it
+// over the old one.
+func (s *presetStore) save() error {
+	data, err := json.MarshalIndent(s.presets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails once renamed
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	// the rename is only durable once the directory is synced
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// userPresets are the figures saved by users, unlike the presets of the
+// playground, which are built in.
+var userPresets *presetStore
+
+// presetInfo describes a preset in listings.
+type presetInfo struct {
+	Name string          `json:"name"`
+	URL  string          `json:"url"` // of its render as a GIF
+	Conf *lissajous.Conf `json:"conf"`
+}
+
+func newPresetInfo(name string, conf *lissajous.Conf) presetInfo {
+	return presetInfo{Name: name, URL: "/presets/" + name + ".gif", Conf: conf}
+}
+
+// presetsHandler serves the collection of presets:
+//
+//	GET /presets       lists them
+//	POST /presets      saves a new one, named by the name form, and
+//	                   described like the figures of /
+//
+// With API keys, saving presets needs one.
+func presetsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if err := requireKey(w, r, false); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		conf, err := presetConf(w, r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		name := r.FormValue("name")
+		if !presetName.MatchString(name) {
+			writeError(w, r, withStatus(http.StatusBadRequest, fmt.Errorf(
+				"bad name value, %s was expected but %q was found",
+				presetName, name)))
+			return
+		}
+
+		if _, err := userPresets.put(name, conf, false); err != nil {
+			if err == errPresetExists {
+				err = withStatus(http.StatusConflict, fmt.Errorf(
+					"preset %s exists, use PUT /presets/%s to update it",
+					name, name))
+			}
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", "/presets/"+name)
+		writeJSON(w, r, http.StatusCreated, newPresetInfo(name, conf))
+		return
+	}
+
+	list := []presetInfo{}
+	for _, name := range userPresets.names() {
+		if conf, ok := userPresets.get(name); ok {
+			list = append(list, newPresetInfo(name, conf))
+		}
+	}
+	writeJSON(w, r, http.StatusOK, list)
+}
+
+// presetHandler serves a preset:
+//
+//	GET /presets/{name}          its figure as JSON
+//	GET /presets/{name}.{format} its render, in any format of /
+//	PUT /presets/{name}          creates or replaces it
+//	DELETE /presets/{name}       deletes it
+//
+// With API keys, saving presets needs one, and deleting them an admin one.
+func presetHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/presets/")
+	var f *format
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		ext := name[i+1:]
+		name = name[:i]
+		if f = lookupFormat(ext, figureFormats); f == nil {
+			writeError(w, r, withStatus(http.StatusNotFound, fmt.Errorf(
+				"unknown format %q, use %s", ext, formatNames(figureFormats))))
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, r, withStatus(http.StatusMethodNotAllowed, fmt.Errorf(
+				"renders of presets are read only, use /presets/%s", name)))
+			return
+		}
+	}
+	if !presetName.MatchString(name) {
+		writeError(w, r, withStatus(http.StatusNotFound,
+			fmt.Errorf("%s not found", r.URL.Path)))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if err := requireKey(w, r, false); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		conf, err := presetConf(w, r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		created, err := userPresets.put(name, conf, true)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		writeJSON(w, r, status, newPresetInfo(name, conf))
+		return
+
+	case http.MethodDelete:
+		if err := requireKey(w, r, true); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		found, err := userPresets.remove(name)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !found {
+			writeError(w, r, withStatus(http.StatusNotFound,
+				fmt.Errorf("preset %s not found", name)))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	conf, ok := userPresets.get(name)
+	if !ok {
+		writeError(w, r, withStatus(http.StatusNotFound,
+			fmt.Errorf("preset %s not found", name)))
+		return
+	}
+	if f == nil {
+		writeJSON(w, r, http.StatusOK, newPresetInfo(name, conf))
+		return
+	}
+	serveFigure(w, r, f, conf)
+}
+
+// presetConf returns the figure described in the request to save as a
+// preset, within the limits of its client.
+func presetConf(w http.ResponseWriter, r *http.Request) (*lissajous.Conf, error) {
+	conf, err := requestConf(w, r)
+	if err == errHelp {
+		return nil, withStatus(http.StatusBadRequest, fmt.Errorf(
+			"describe the figure with forms or a JSON body, like for /"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the format of later renders is not known, so all the frames count
+	if err := checkLimits(r, conf, conf.NFrames); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
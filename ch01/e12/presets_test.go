@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+func TestPresetStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "presets.json")
+	s, err := newPresetStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	knot := lissajous.DefaultConf()
+	knot.Cycles, knot.FreqDiff = 3, 1.5
+	for _, step := range []struct {
+		name    string
+		replace bool
+		created bool
+		err     error
+	}{
+		{"knot", false, true, nil},
+		{"knot", false, false, errPresetExists},
+		{"knot", true, false, nil},
+		{"other", false, true, nil},
+	} {
+		created, err := s.put(step.name, knot, step.replace)
+		if created != step.created || err != step.err {
+			t.Errorf("put(%s, replace %v) = %v, %v, want %v, %v",
+				step.name, step.replace, created, err, step.created, step.err)
+		}
+	}
+	if removed, err := s.remove("other"); !removed || err != nil {
+		t.Errorf("remove(other) = %v, %v, want true, nil", removed, err)
+	}
+	if removed, err := s.remove("other"); removed || err != nil {
+		t.Errorf("remove(other) again = %v, %v, want false, nil", removed, err)
+	}
+
+	// the presets survive the store, and no temporary files are left
+	again, err := newPresetStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := again.names(); !reflect.DeepEqual(got, []string{"knot"}) {
+		t.Errorf("names after reopening: got %v, want [knot]", got)
+	}
+	if got, _ := again.get("knot"); !reflect.DeepEqual(got, knot) {
+		t.Errorf("knot after reopening: got %+v, want %+v", got, knot)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d files, want only the presets", len(files))
+	}
+}
+
+func TestPresetStoreSaveFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "presets.json")
+	s, err := newPresetStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.put("knot", lissajous.DefaultConf(), false); err == nil {
+		t.Fatal("put did not fail")
+	}
+	if _, ok := s.get("knot"); ok {
+		t.Error("the preset is kept although it was not saved")
+	}
+}
+
+func TestPresetStoreBadFile(t *testing.T) {
+	for name, data := range map[string]string{
+		"bad JSON":   `{"knot":`,
+		"bad figure": `{"knot":{"cycles":0}}`,
+	} {
+		path := filepath.Join(t.TempDir(), "presets.json")
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newPresetStore(path); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
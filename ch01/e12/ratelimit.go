@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket: it holds up to burst tokens, refilled at rate
+// tokens per second, and every request takes one.  Every client has its own
+// rate and burst.
+type bucket struct {
+	tokens float64
+	last   time.Time // of the last refill
+}
+
+// limiter keeps a token bucket per client.
+type limiter struct {
+	idle time.Duration // buckets unused for longer are forgotten
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func newLimiter(idle time.Duration) *limiter {
+	l := &limiter{
+		idle:    idle,
+		buckets: make(map[string]*bucket),
+	}
+	go l.cleanup()
+	return l
+}
+
+// take takes a token from the bucket of the client, with the given rate
+// and burst.  If there are none, it returns how long until there is one.
+func (l *limiter) take(client string, rate float64, burst int, now time.Time) (ok bool, wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, found := l.buckets[client]
+	if !found {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+
+	return true, 0
+}
+
+// cleanup forgets idle buckets from time to time, as they would be full
+// again anyway.
+func (l *limiter) cleanup() {
+	for now := range time.Tick(l.idle / 2) {
+		l.mu.Lock()
+		for client, b := range l.buckets {
+			if now.Sub(b.last) > l.idle {
+				delete(l.buckets, client)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// rateLimit returns a handler that answers with 429 Too Many Requests the
+// clients that run out of tokens.  Clients without a rate are not limited.
+func rateLimit(h http.Handler, l *limiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := requestClient(r)
+		if c.rate <= 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ok, wait := l.take(c.id, c.rate, c.burst, time.Now())
+		if !ok {
+			writeError(w, r, &httpError{
+				status:     http.StatusTooManyRequests,
+				err:        fmt.Errorf("too many requests, try again in %s", wait.Round(time.Millisecond)),
+				retryAfter: wait,
+			})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// unlimited returns a handler that serves the requests for the paths with
+// bypass, and the rest with h, so health checks and scrapes are never
+// rate limited.
+func unlimited(h, bypass http.Handler, paths ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range paths {
+			if r.URL.Path == p {
+				bypass.ServeHTTP(w, r)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// trustedProxies are the networks of the proxies whose X-Forwarded-For
+// headers are believed.
+var trustedProxies []*net.IPNet
+
+// parseNetworks parses a comma separated list of IPs and CIDR networks.
+func parseNetworks(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			if ip := net.ParseIP(part); ip != nil && ip.To4() != nil {
+				part += "/32"
+			} else {
+				part += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func trusted(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client of the request.  Requests from
+// trusted proxies, or from unix sockets, which are local, come from the
+// last address of their X-Forwarded-For headers that is not a trusted
+// proxy too.
+func clientIP(r *http.Request) string {
+	client := "-" // unix sockets have no remote address
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil && host != "" {
+		if ip := net.ParseIP(host); ip == nil || !trusted(ip) {
+			return host
+		}
+		client = host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			// anything before a malformed address cannot be trusted
+			break
+		}
+		client = ip.String()
+		if !trusted(ip) {
+			break
+		}
+	}
+
+	return client
+}
+
+// localRequest tells if the client of the request is on this host.
+func localRequest(r *http.Request) bool {
+	ip := net.ParseIP(clientIP(r))
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLimiterTake(t *testing.T) {
+	const rate, burst = 2, 3
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := newLimiter(time.Hour)
+	for i, step := range []struct {
+		client string
+		at     time.Duration // since start
+		ok     bool
+		wait   time.Duration
+	}{
+		// the burst is available at once
+		{"a", 0, true, 0},
+		{"a", 0, true, 0},
+		{"a", 0, true, 0},
+		{"a", 0, false, 500 * time.Millisecond},
+		// clients have buckets of their own
+		{"b", 0, true, 0},
+		// tokens come back at the rate
+		{"a", 250 * time.Millisecond, false, 250 * time.Millisecond},
+		{"a", 500 * time.Millisecond, true, 0},
+		{"a", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		// but never above the burst
+		{"a", time.Minute, true, 0},
+		{"a", time.Minute, true, 0},
+		{"a", time.Minute, true, 0},
+		{"a", time.Minute, false, 500 * time.Millisecond},
+	} {
+		ok, wait := l.take(step.client, rate, burst, start.Add(step.at))
+		if ok != step.ok || wait != step.wait {
+			t.Errorf("step %d: take(%s) at %s = %v, %s, want %v, %s",
+				i, step.client, step.at, ok, wait, step.ok, step.wait)
+		}
+	}
+}
+
+// TestRateLimitKeys checks that API keys cannot be used to get fresh
+// buckets: without authentication they are ignored, and with it unknown
+// ones are rejected before reaching the limiter.
+func TestRateLimitKeys(t *testing.T) {
+	oldKeys, oldRate, oldBurst := apiKeys, defaultRate, defaultBurst
+	t.Cleanup(func() { apiKeys, defaultRate, defaultBurst = oldKeys, oldRate, oldBurst })
+	defaultRate, defaultBurst = 1, 2
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := authenticate(rateLimit(ok, newLimiter(time.Hour)))
+	get := func(remoteAddr, key string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	apiKeys = nil
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if got := get("192.0.2.1:1234", "random"+strconv.Itoa(i)); got != want {
+			t.Errorf("request %d without authentication: got %d, want %d", i, got, want)
+		}
+	}
+	if got := get("192.0.2.2:1234", "random"); got != http.StatusOK {
+		t.Errorf("request from another address: got %d, want %d", got, http.StatusOK)
+	}
+
+	apiKeys = map[string]*apiKey{hashSecret("secret"): {Name: "test"}}
+	if got := get("192.0.2.3:1234", "random"); got != http.StatusUnauthorized {
+		t.Errorf("request with an unknown key: got %d, want %d", got, http.StatusUnauthorized)
+	}
+}
@@ -1,163 +1,750 @@
 package main
 
-// TODO: write help using a template.
-//
-// TODO: formToConf is too repetitive, fix this by using a data structure and
-//       values of it for each option.
-
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
+	"os"
+	"runtime"
 	"strconv"
+	"time"
 
 	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
 )
 
 var errHelp = errors.New("")
 
+const maxJSONBody = 1 << 20 // bytes
+
+var (
+	renderLimits  limits
+	renderQueue   *admission
+	renderTimeout time.Duration
+	renders       *renderCache
+	renderFlight  = newFlight()
+	cacheMaxAge   time.Duration
+)
+
 func main() {
-	http.HandleFunc("/", lissajousGif)
-	log.Fatal(http.ListenAndServe("localhost:8000", nil))
-}
+	flag.IntVar(&renderLimits.maxPixels, "max-pixels", 2000*2000,
+		"maximum pixels of a frame, counting supersampling")
+	flag.IntVar(&renderLimits.maxFrames, "max-frames", 256,
+		"maximum frames of an animation")
+	flag.Float64Var(&renderLimits.maxSamples, "max-samples", 50e6,
+		"maximum points drawn in a whole animation")
+	flag.Float64Var(&renderLimits.maxMemory, "max-memory", 100e6,
+		"maximum pixels of all the frames of an animation, counting supersampling, "+
+			"as they are kept in memory while rendering")
+	flag.Float64Var(&maxBatchSamples, "max-batch-samples", 2e9,
+		"maximum points drawn by all the figures of a batch")
+	flag.Float64Var(&maxBatchPixels, "max-batch-pixels", 2e9,
+		"maximum pixels of all the frames of all the figures of a batch")
+	maxRenders := flag.Int("max-renders", runtime.NumCPU(),
+		"maximum renders running at the same time")
+	maxQueue := flag.Int("max-queue", 16,
+		"maximum renders waiting for their turn")
+	queueTimeout := flag.Duration("queue-timeout", 10*time.Second,
+		"how long a render can wait for its turn")
+	flag.DurationVar(&renderTimeout, "render-timeout", 30*time.Second,
+		"how long a render can take")
+	cacheSize := flag.Int("cache-size", 64<<20,
+		"maximum bytes of renders cached in memory")
+	cacheDir := flag.String("cache-dir", "",
+		"directory to cache renders on disk, none by default")
+	cacheDirSize := flag.Int64("cache-dir-size", 1<<30,
+		"maximum bytes of renders cached on disk")
+	flag.DurationVar(&cacheMaxAge, "cache-max-age", time.Hour,
+		"how long clients can cache renders")
+	addr := flag.String("addr", "localhost:8000",
+		"address to listen on, host:port or unix:/path/to/socket")
+	var t timeouts
+	flag.DurationVar(&t.readHeader, "read-header-timeout", 10*time.Second,
+		"how long reading the headers of a request can take")
+	flag.DurationVar(&t.read, "read-timeout", time.Minute,
+		"how long reading a whole request can take")
+	flag.DurationVar(&t.write, "write-timeout", 2*time.Minute,
+		"how long handling a request and writing its response can take")
+	flag.DurationVar(&t.idle, "idle-timeout", 2*time.Minute,
+		"how long an idle connection is kept open")
+	flag.DurationVar(&t.shutdown, "shutdown-timeout", time.Minute,
+		"how long to wait for requests and renders when shutting down")
+	rate := flag.Float64("rate", 10,
+		"requests per second allowed to each client, 0 for no limit")
+	burst := flag.Int("burst", 40,
+		"requests a client can make at once, above the rate")
+	rateIdle := flag.Duration("rate-idle", 10*time.Minute,
+		"how long the rate of an idle client is remembered")
+	proxies := flag.String("trusted-proxies", "",
+		"comma separated IPs and CIDR networks of the proxies whose X-Forwarded-For headers are believed")
+	jobWorkers := flag.Int("job-workers", 2,
+		"jobs running at the same time")
+	jobQueue := flag.Int("job-queue", 100,
+		"jobs waiting for a worker")
+	jobTimeout := flag.Duration("job-timeout", 10*time.Minute,
+		"how long a job can run")
+	jobTTL := flag.Duration("job-ttl", time.Hour,
+		"how long jobs and their results are kept")
+	jobResultsSize := flag.Int("job-results-size", 256<<20,
+		"maximum bytes of the results of jobs kept, the oldest ones are forgotten first")
+	useTLS := flag.Bool("tls", false,
+		"serve HTTPS, with a self-signed certificate if tls-cert and tls-key are not given")
+	tlsCert := flag.String("tls-cert", "",
+		"file of the TLS certificate, PEM encoded; implies tls")
+	tlsKey := flag.String("tls-key", "",
+		"file of the key of the TLS certificate, PEM encoded; implies tls")
+	tlsDir := flag.String("tls-dir", "tls",
+		"directory to keep the generated self-signed certificate in")
+	tlsHostsFlag := flag.String("tls-hosts", "",
+		"comma separated names and IPs of the generated certificate, besides localhost")
+	redirectAddr := flag.String("http-redirect", "",
+		"address to redirect plain HTTP requests from to HTTPS, none by default")
+	maxViewers := flag.Int("max-viewers", 16,
+		"maximum live streams watched at the same time")
+	presetsFile := flag.String("presets", "presets.json",
+		"file to keep the presets in")
+	linksFile := flag.String("links", "links.jsonl",
+		"file to keep the short links in")
+	keysFile := flag.String("keys", "",
+		"JSON file of API keys, none by default, which turns authentication off")
+	genKey := flag.Bool("new-key", false,
+		"print a new API key secret and its hash for the keys file, and exit")
+	flag.IntVar(&defaultDaily, "daily-renders", 1000,
+		"renders a day allowed to each client without keys, 0 for no quota")
+	flag.IntVar(&anonLimits.maxPixels, "anon-max-pixels", 800*800,
+		"maximum pixels of a frame for anonymous clients, with keys")
+	flag.IntVar(&anonLimits.maxFrames, "anon-max-frames", 64,
+		"maximum frames of an animation for anonymous clients, with keys")
+	flag.Float64Var(&anonLimits.maxSamples, "anon-max-samples", 5e6,
+		"maximum samples for anonymous clients, with keys")
+	flag.Float64Var(&anonLimits.maxMemory, "anon-max-memory", 800*800*64,
+		"maximum pixels of all the frames for anonymous clients, with keys")
+	flag.IntVar(&anonDaily, "anon-daily-renders", 200,
+		"renders a day allowed to each anonymous client, with keys, 0 for no quota")
+	flag.Float64Var(&anonRate, "anon-rate", 1,
+		"requests per second allowed to each anonymous client, with keys, 0 for no limit")
+	flag.IntVar(&anonBurst, "anon-burst", 10,
+		"requests an anonymous client can make at once, with keys")
+	debug := flag.Bool("debug", false,
+		"serve the request inspector under /debug/, only meant for testing clients")
+	logFormat := flag.String("access-log", logCommon,
+		"format of the access log written to stdout: common, json or off")
+	if err := parseFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if *genKey {
+		if err := newKey(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	switch *logFormat {
+	case logCommon, logJSON, logOff:
+	default:
+		log.Fatalf("bad access-log value, common, json or off was expected but %q was found",
+			*logFormat)
+	}
 
-func dumpRequest(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "%s %s %s\n", r.Method, r.URL, r.Proto)
-	for k, v := range r.Header {
-		fmt.Fprintf(w, "Header[%q] = %q\n", k, v)
+	var tlsConf *tls.Config
+	var err error
+	if *useTLS || *tlsCert != "" || *tlsKey != "" {
+		if (*tlsCert == "") != (*tlsKey == "") {
+			log.Fatal("tls-cert and tls-key have to be given together")
+		}
+		tlsConf, err = tlsConfig(*tlsCert, *tlsKey, *tlsDir,
+			tlsHosts(*tlsHostsFlag, *addr))
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if *redirectAddr != "" {
+		log.Fatal("http-redirect needs tls")
 	}
-	fmt.Fprintf(w, "Host = %q\n", r.Host)
-	fmt.Fprintf(w, "RemoteAddr = %q\n", r.RemoteAddr)
-	if err := r.ParseForm(); err != nil {
-		log.Print(err)
+
+	if trustedProxies, err = parseNetworks(*proxies); err != nil {
+		log.Fatalf("bad trusted-proxies value: %v", err)
 	}
-	for k, v := range r.Form {
-		fmt.Fprintf(w, "Form[%q] = %q\n", k, v)
+
+	defaultRate, defaultBurst = *rate, *burst
+	if *keysFile != "" {
+		if apiKeys, err = loadKeys(*keysFile); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%d API keys loaded from %s", len(apiKeys), *keysFile)
+	}
+
+	renderQueue = newAdmission(*maxRenders, *maxQueue, *queueTimeout)
+
+	var disk *diskCache
+	if *cacheDir != "" {
+		if disk, err = newDiskCache(*cacheDir, *cacheDirSize); err != nil {
+			log.Fatal(err)
+		}
+	}
+	renders = newRenderCache(*cacheSize, disk)
+
+	liveViewers = make(chan struct{}, *maxViewers)
+	go dropIdleLiveStreams()
+	jobs = newJobRunner(*jobWorkers, *jobQueue, *jobTimeout, *jobTTL, *jobResultsSize)
+
+	if userPresets, err = newPresetStore(*presetsFile); err != nil {
+		log.Fatal(err)
+	}
+	if links, err = newLinkStore(*linksFile); err != nil {
+		log.Fatal(err)
+	}
+
+	rts := routes(*debug)
+	for _, rt := range rts {
+		h := rt.handler
+		if rt.methods != nil {
+			h = allow(h, rt.methods...)
+		}
+		if rt.pattern == "/" { // it matches every path
+			h = exactPath(rt.pattern, h)
+		}
+		http.HandleFunc(rt.pattern, h)
+	}
+	apiDoc = openAPI(rts)
+
+	h := unlimited(rateLimit(http.DefaultServeMux, newLimiter(*rateIdle)),
+		http.DefaultServeMux, "/healthz", "/readyz", "/metrics")
+	accessLog := &accessLog{out: os.Stdout, format: *logFormat}
+	if err := serve(*addr, observe(authenticate(h), accessLog), t, tlsConf, *redirectAddr); err != nil {
+		log.Fatal(err)
 	}
 }
 
-func lissajousGif(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
-		log.Print(err)
+// routes returns the handlers of the server, and how /openapi.json
+// describes them.  The request inspector is only among them if debug is
+// true.
+func routes(debug bool) []route {
+	read := []string{http.MethodGet, http.MethodHead}
+	readOrPost := append(read, http.MethodPost)
+	get := []string{http.MethodGet}
+	post := []string{http.MethodPost}
+
+	jsonTypes := []string{"application/json"}
+	textTypes := []string{"text/plain"}
+	figureTypes := uniqueMediaTypes(figureFormats)
+	formatForm := apiForm{"format", "string",
+		"format of the render, one of " + formatNames(figureFormats)}
+	frameForm := apiForm{"frame", "integer",
+		"frame drawn by still formats, from 0, the first one by default"}
+	nameForm := apiForm{"name", "string", "name of the live stream, default by default; others are created by changing them"}
+
+	rts := []route{
+		{
+			pattern: "/", methods: readOrPost,
+			handler: render(figureFormats),
+			api: []apiPath{{
+				path: "/", summary: "Renders a figure, in the format form or the negotiated one",
+				figure: true, forms: []apiForm{formatForm, frameForm}, types: figureTypes,
+			}},
+		},
+		{
+			pattern: "/exposure", methods: readOrPost,
+			handler: render([]*format{formatExposure}),
+			api: []apiPath{{
+				path: "/exposure", summary: "Renders all the frames of a figure in a single image",
+				figure: true, types: []string{formatExposure.mediaType},
+			}},
+		},
+		{
+			pattern: "/params", methods: read, handler: listParams,
+			api: []apiPath{{path: "/params", summary: "Lists the parameters of figures", types: jsonTypes}},
+		},
+		{
+			pattern: "/playground", methods: read, handler: playgroundHandler,
+			api: []apiPath{{path: "/playground", summary: "Edits figures interactively",
+				types: []string{"text/html"}}},
+		},
+		{
+			pattern: "/assets/", methods: read, handler: assetsHandler().ServeHTTP,
+			api: []apiPath{{
+				path: "/assets/{file}", summary: "Returns a file of the playground and the gallery",
+				forms: []apiForm{{"file", "string", "name of the file"}},
+				types: []string{"text/html", "text/css", "text/javascript"},
+			}},
+		},
+		{
+			pattern: "/healthz", methods: read, handler: healthz,
+			api: []apiPath{{path: "/healthz", summary: "Tells if the server is alive", types: textTypes}},
+		},
+		{
+			pattern: "/readyz", methods: read, handler: readyz,
+			api: []apiPath{{path: "/readyz", summary: "Tells if the server takes renders", types: textTypes}},
+		},
+		{
+			pattern: "/presets", methods: readOrPost, handler: presetsHandler,
+			api: []apiPath{
+				{path: "/presets", methods: get, summary: "Lists the presets", types: jsonTypes},
+				{
+					path: "/presets", methods: post, summary: "Saves a new preset",
+					figure: true, status: http.StatusCreated, types: jsonTypes,
+					forms: []apiForm{{"name", "string", "name of the preset"}},
+				},
+			},
+		},
+		{
+			pattern: "/presets/",
+			methods: append(read, http.MethodPut, http.MethodDelete),
+			handler: presetHandler,
+			api: []apiPath{
+				{
+					path: "/presets/{name}", methods: get, summary: "Returns a preset",
+					forms: []apiForm{{"name", "string", "name of the preset"}},
+					types: jsonTypes, conf: true,
+				},
+				{
+					path: "/presets/{name}", methods: []string{http.MethodPut},
+					summary: "Creates or replaces a preset", figure: true,
+					forms: []apiForm{{"name", "string", "name of the preset"}},
+					types: jsonTypes,
+				},
+				{
+					path: "/presets/{name}", methods: []string{http.MethodDelete},
+					summary: "Deletes a preset",
+					forms:   []apiForm{{"name", "string", "name of the preset"}},
+				},
+				{
+					path: "/presets/{name}.{format}", methods: get, summary: "Renders a preset",
+					forms: []apiForm{{"name", "string", "name of the preset"}, formatForm, frameForm},
+					types: figureTypes,
+				},
+			},
+		},
+		{
+			pattern: "/gallery", methods: read, handler: galleryHandler,
+			api: []apiPath{{
+				path: "/gallery", summary: "Shows the figures rendered lately",
+				forms: []apiForm{
+					{"sort", "string", "recent or popular"},
+					{"page", "integer", "page, from 1"},
+				},
+				types: []string{"text/html"},
+			}},
+		},
+		{
+			pattern: "/gallery/", methods: read, handler: galleryHandler,
+			api: []apiPath{{
+				path: "/gallery/{id}", summary: "Returns the PNG thumbnail of a figure of the gallery",
+				forms: []apiForm{{"id", "string", "ID of the figure"}},
+				types: []string{"image/png"},
+			}},
+		},
+		{
+			pattern: "/s", methods: post, handler: linksHandler,
+			api: []apiPath{{
+				path: "/s", summary: "Returns the short link of a figure",
+				figure: true, status: http.StatusCreated, types: jsonTypes,
+			}},
+		},
+		{
+			pattern: "/s/", methods: read, handler: linkHandler,
+			api: []apiPath{
+				{
+					path: "/s/{id}", summary: "Redirects to the playground with the figure of a link",
+					forms:  []apiForm{{"id", "string", "ID of the link"}},
+					status: http.StatusFound,
+				},
+				{
+					path: "/s/{id}.{format}", summary: "Renders the figure of a link",
+					forms: []apiForm{{"id", "string", "ID of the link"}, formatForm, frameForm},
+					types: figureTypes,
+				},
+			},
+		},
+		{
+			pattern: "/jobs", methods: post, handler: jobsHandler,
+			api: []apiPath{{
+				path: "/jobs", summary: "Renders a figure in the background",
+				figure: true, forms: []apiForm{formatForm, frameForm},
+				status: http.StatusAccepted, types: jsonTypes,
+			}},
+		},
+		{
+			pattern: "/jobs/", methods: append(read, http.MethodDelete), handler: jobHandler,
+			api: []apiPath{
+				{
+					path: "/jobs/{id}", methods: get, summary: "Returns the status of a job",
+					forms: []apiForm{{"id", "string", "ID of the job"}}, types: jsonTypes,
+				},
+				{
+					path: "/jobs/{id}", methods: []string{http.MethodDelete},
+					summary: "Cancels and forgets a job",
+					forms:   []apiForm{{"id", "string", "ID of the job"}},
+				},
+				{
+					path: "/jobs/{id}/result", methods: get, summary: "Returns the render of a job",
+					forms: []apiForm{{"id", "string", "ID of the job"}}, types: figureTypes,
+				},
+			},
+		},
+		{
+			pattern: "/live/stream", methods: read, handler: liveStreamHandler,
+			api: []apiPath{{
+				path: "/live/stream", summary: "Streams the frames of a live stream",
+				forms: []apiForm{
+					nameForm,
+					{"type", "string", "png or jpeg"},
+					{"fps", "number", "frames per second, by default the ones of the delay"},
+				},
+				types: []string{"multipart/x-mixed-replace"},
+			}},
+		},
+		{
+			pattern: "/live/events", methods: read, handler: liveEventsHandler,
+			api: []apiPath{{
+				path: "/live/events", summary: "Pushes the figure of a live stream on every change",
+				forms: []apiForm{nameForm}, types: []string{"text/event-stream"},
+			}},
+		},
+		{
+			pattern: "/live/control", methods: readOrPost, handler: liveControlHandler,
+			api: []apiPath{
+				{
+					path: "/live/control", methods: get, summary: "Returns the figure of a live stream",
+					forms: []apiForm{nameForm}, types: jsonTypes, conf: true,
+				},
+				{
+					path: "/live/control", methods: post, summary: "Changes the figure of a live stream",
+					figure: true, forms: []apiForm{nameForm}, types: jsonTypes, conf: true,
+				},
+			},
+		},
+		{
+			pattern: "/batch", methods: post, handler: batchHandler,
+			api: []apiPath{{
+				path: "/batch", summary: "Renders a list or a sweep of figures into a ZIP archive",
+				body: "application/json", forms: []apiForm{formatForm, frameForm},
+				types: []string{"application/zip"},
+			}},
+		},
+		{
+			pattern: "/metrics", methods: read, handler: metricsHandler,
+			api: []apiPath{{path: "/metrics", summary: "Returns Prometheus metrics", types: textTypes}},
+		},
+		{
+			pattern: "/admin/usage", methods: read, handler: adminUsage,
+			api: []apiPath{{
+				path: "/admin/usage", summary: "Returns the usage of the clients, to admin keys, or to local requests without keys",
+				forms: []apiForm{{"day", "string", "day in UTC, as YYYY-MM-DD, today by default"}},
+				types: jsonTypes,
+			}},
+		},
+		{
+			pattern: "/openapi.json", methods: read, handler: openAPIHandler,
+			api: []apiPath{{path: "/openapi.json", summary: "Returns this document", types: jsonTypes}},
+		},
 	}
+	if debug {
+		rts = append(rts, route{pattern: "/debug/", handler: debugHandler, api: debugAPI})
+	}
+	return rts
+}
 
-	conf, err := formToConf(r.Form)
-	if err != nil {
-		if err == errHelp {
-			fmt.Fprintf(w, help)
+// render returns a handler that draws the figure described in the request
+// form, or in its JSON body, in the format negotiated among offers.
+//
+// Renders are subject to the limits and the daily quota of the client,
+// and are given up once they take longer than the render timeout.  They
+// are cached, and concurrent requests for the same figure share a single
+// render.
+func render(offers []*format) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conf, err := requestConf(w, r)
+		if err != nil {
+			if err == errHelp {
+				servePlayground(w, r, nil)
+				return
+			}
+			writeError(w, r, err)
+			return
+		}
+
+		if len(offers) > 1 {
+			w.Header().Add("Vary", "Accept")
+		}
+		f, err := negotiate(r, offers)
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
-		fmt.Fprintf(w, "Error: %s\n", err)
+		serveFigure(w, r, f, conf)
+	}
+}
+
+// serveFigure answers the request with the figure in the format, from the
+// cache if possible.
+func serveFigure(w http.ResponseWriter, r *http.Request, f *format, conf *lissajous.Conf) {
+	frame, err := frameForm(r, conf)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := checkLimits(r, conf, renderedFrames(f, conf)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	c := requestClient(r)
+	entry, err := cachedRender(c, f, frame, conf)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	lissajous.Gif(w, conf)
+	usage.addBytes(c, len(entry.body))
+	gallery.record(conf)
+	if err := cacheThumbnail(conf); err != nil {
+		log.Printf("%s thumbnail: %v", requestID(r), err)
+	}
+	serveEntry(w, r, f.mediaType, entry)
 }
 
-func formToConf(forms url.Values) (*lissajous.Conf, error) {
-	if len(forms) == 0 {
-		return nil, errHelp
+// cachedRender returns the render of the figure from the cache, rendering
+// it if it is not there.  Only renders count in the daily quota of the
+// client: not cache hits, nor waiting for the same render of another
+// request.
+func cachedRender(c *client, f *format, frame int, conf *lissajous.Conf) (*cacheEntry, error) {
+	key := cacheKey(f, frame, conf)
+	for {
+		if entry := renders.get(key); entry != nil {
+			cacheLookups.add("hit", 1)
+			return entry, nil
+		}
+
+		cacheLookups.add("miss", 1)
+		started := false
+		entry, err := renderFlight.do(key, func() (*cacheEntry, error) {
+			started = true
+			if err := usage.take(c, 1); err != nil {
+				return nil, quotaError{err}
+			}
+			return renderEntry(f, frame, conf, key)
+		})
+		var quota quotaError
+		if !errors.As(err, &quota) {
+			return entry, err
+		}
+		if started {
+			return nil, quota.err
+		}
+		// the render waited for was refused to another client, so this
+		// one tries on its own
 	}
+}
 
-	conf := lissajous.DefaultConf()
-	var err error
+// quotaError is the error of a render refused by the quota of the client
+// that started it, which does not apply to the ones waiting for it.
+type quotaError struct{ err error }
+
+func (e quotaError) Error() string { return e.err.Error() }
 
-	for k, v := range forms {
-		if len(v) != 1 {
-			return nil, fmt.Errorf(
-				"bad number of arguments to %q form: expected 1, found %d",
-				k, len(v))
+// renderEntry renders the figure and adds it to the cache.  The render
+// does not depend on the request that started it, as other requests for
+// the same figure may be waiting for it.
+func renderEntry(f *format, frame int, conf *lissajous.Conf, key string) (*cacheEntry, error) {
+	release, err := admit(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), renderTimeout)
+	defer cancel()
+
+	// render to memory, so failures can still be answered with the right
+	// status
+	var buf bytes.Buffer
+	start := time.Now()
+	err = f.render(ctx, &buf, conf, frame)
+	renderDuration.observe(f.id, time.Since(start).Seconds())
+	if err != nil {
+		if err == context.DeadlineExceeded {
+			err = withStatus(http.StatusServiceUnavailable, fmt.Errorf(
+				"the render took longer than %s, try a smaller figure",
+				renderTimeout))
 		}
-		switch k {
-		case "cycles":
-			conf.Cycles, err = strconv.Atoi(v[0])
-			if err != nil {
-				return nil, fmt.Errorf(
-					"bad cycle value, an int was expected but %s was found",
-					v[0])
-			}
-		case "res":
-			conf.Res, err = strconv.ParseFloat(v[0], 64)
-			if err != nil {
-				return nil, fmt.Errorf(
-					"bad res value, a float was expected but %s was found",
-					v[0])
-			}
-		case "side":
-			conf.Side, err = strconv.Atoi(v[0])
-			if err != nil {
-				return nil, fmt.Errorf(
-					"bad side value, an int was expected but %s was found",
-					v[0])
-			}
-		case "nframes":
-			conf.NFrames, err = strconv.Atoi(v[0])
-			if err != nil {
-				return nil, fmt.Errorf(
-					"bad nframes value, an int was expected but %s was found",
-					v[0])
-			}
-		case "delay":
-			conf.Delay, err = strconv.Atoi(v[0])
-			if err != nil {
-				return nil, fmt.Errorf(
-					"bad delay value, an int was expected but %s was found",
-					v[0])
-			}
-		case "phaseInc":
-			conf.PhaseInc, err = strconv.ParseFloat(v[0], 64)
+		return nil, err
+	}
+
+	entry := newCacheEntry(buf.Bytes())
+	renders.add(key, entry)
+
+	return entry, nil
+}
+
+// requestConf returns the figure described in the request.  It returns
+// errHelp for requests without any description.
+func requestConf(w http.ResponseWriter, r *http.Request) (*lissajous.Conf, error) {
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "application/json":
+			body, err := readJSONBody(w, r)
 			if err != nil {
-				return nil, fmt.Errorf(
-					"bad phaseInc value, an int was expected but %s was found",
-					v[0])
+				return nil, err
 			}
-		case "freqDiff":
-			conf.FreqDiff, err = strconv.ParseFloat(v[0], 64)
+			conf, err := jsonToConf(bytes.NewReader(body))
 			if err != nil {
-				return nil, fmt.Errorf(
-					"bad phaseInc value, an int was expected but %s was found",
-					v[0])
+				return nil, withStatus(http.StatusBadRequest, err)
 			}
+			return conf, nil
+		case "application/x-www-form-urlencoded", "multipart/form-data":
+		default:
+			return nil, withStatus(http.StatusUnsupportedMediaType, fmt.Errorf(
+				"unsupported content type %q, use application/json or a form",
+				mediaType))
 		}
 	}
 
+	if err := r.ParseForm(); err != nil {
+		return nil, withStatus(http.StatusBadRequest, err)
+	}
+
+	conf, err := formToConf(r.Form)
+	if err != nil && err != errHelp {
+		return nil, withStatus(http.StatusBadRequest, err)
+	}
+
+	return conf, err
+}
+
+// writeBody answers the request with body, leaving it out for HEAD
+// requests.
+func writeBody(w http.ResponseWriter, r *http.Request, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if _, err := w.Write(body); err != nil {
+		log.Print(err)
+	}
+}
+
+// formToConf parses the forms described in lissajous.Params, and validates
+// the figure.  Unknown forms are ignored.
+func formToConf(forms url.Values) (*lissajous.Conf, error) {
+	if len(forms) == 0 {
+		return nil, errHelp
+	}
+
+	conf := new(lissajous.Conf)
+	if err := conf.UnmarshalValues(forms); err != nil {
+		return nil, err
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
 	return conf, nil
 }
 
-const help = `<html>
-<body>
-<h1>What is this?</h1>
-
-<p>Foo bar.</p>
-
-<h1>Usage</h1>
-
-Accepted forms:
-<ul>
-<li>cycles   = <int>:   number of complete x oscillator revolutions (default: 4)</li>
-<li>res      = <float>: angular resolution (default: 0.001)</li>
-<li>side     = <int>:   image canvas side in pixels [0..side] (default: 400)</li>
-<li>nframes  = <int>:   number of animation frames (default: 64)</li>
-<li>delay    = <int>:   delay between frames in 10ms units (default: 8)</li>
-<li>phaseInc = <float>: how much phase to increment in each frame (default: 0.1)</li>
-<li>freqDiff = <float>: frequency difference between x and y (default: 2.3)</li>
-</ul>
-
-
-<h1>Examples</h1>
-<ul>
-	<li>
-	<a href="http://localhost:8000/?cycles=2&freqDiff=1&side=1000">http://localhost:8000/?cycles=2&freqDiff=1&side=1000</a>
-	</li>
-	<li>
-	<a href="http://localhost:8000/?cycles=2&freqDiff=2">http://localhost:8000/?cycles=2&freqDiff=2</a>
-	</li>
-	<li>
-<a href="http://localhost:8000/?cycles=4&freqDiff=2.3&phaseInc=0.1">http://localhost:8000/?cycles=4&freqDiff=2.3&phaseInc=0.1</a>
-	</li>
-</ul>
-
-</body>
-</html>`
+// paramInfo is the description of a parameter in the /params listing.
+type paramInfo struct {
+	Name        string         `json:"name"`
+	Type        lissajous.Kind `json:"type"`
+	Layer       bool           `json:"layer"`
+	Default     string         `json:"default"`
+	Min         *float64       `json:"min,omitempty"`
+	Max         *float64       `json:"max,omitempty"`
+	Values      []string       `json:"values,omitempty"`
+	Description string         `json:"description"`
+}
+
+func newParamInfo(p *lissajous.Param) paramInfo {
+	info := paramInfo{
+		Name:        p.Name,
+		Type:        p.Kind,
+		Layer:       p.Layer,
+		Default:     p.Default(),
+		Values:      p.Values,
+		Description: p.Description,
+	}
+	switch p.Kind {
+	case lissajous.KindInt, lissajous.KindFloat, lissajous.KindInts:
+		min, max := p.Min, p.Max
+		info.Min, info.Max = &min, &max
+	}
+
+	return info
+}
+
+func listParams(w http.ResponseWriter, r *http.Request) {
+	infos := make([]paramInfo, len(lissajous.Params))
+	for i, p := range lissajous.Params {
+		infos[i] = newParamInfo(p)
+	}
+
+	writeJSON(w, r, http.StatusOK, infos)
+}
+
+// writeJSON answers the request with v encoded as indented JSON.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	body = append(body, '\n')
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if _, err := w.Write(body); err != nil {
+		log.Print(err)
+	}
+}
+
+// readJSONBody reads the JSON body of the request.  Bodies larger than
+// maxJSONBody are answered with 413.
+func readJSONBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONBody))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, withStatus(http.StatusRequestEntityTooLarge, fmt.Errorf(
+			"the JSON body is larger than %d bytes", tooLarge.Limit))
+	}
+	if err != nil {
+		return nil, withStatus(http.StatusBadRequest, err)
+	}
+	return body, nil
+}
+
+// jsonToConf decodes a Conf from a JSON document, and validates it.  Like
+// in the forms, the layers start as a copy of the base layer.
+func jsonToConf(r io.Reader) (*lissajous.Conf, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := new(lissajous.Conf)
+	if err := json.Unmarshal(body, conf); err != nil {
+		var fieldErrs lissajous.Errors
+		if errors.As(err, &fieldErrs) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("bad JSON body: %s", err)
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/tgpl-exercises/ch01/e12/lissajous"
+)
+
+// TestCachedRenderQuota checks that only the requests that render count
+// in the daily quota of their clients.
+func TestCachedRenderQuota(t *testing.T) {
+	oldRenders, oldUsage, oldQueue, oldTimeout := renders, usage, renderQueue, renderTimeout
+	t.Cleanup(func() { renders, usage, renderQueue, renderTimeout = oldRenders, oldUsage, oldQueue, oldTimeout })
+	renders = newRenderCache(1<<20, nil)
+	usage = &usageTracker{days: make(map[string]map[string]*clientUsage)}
+	renderQueue = newAdmission(2, 2, time.Second)
+	renderTimeout = time.Minute
+
+	figure := func(cycles int) *lissajous.Conf {
+		c := lissajous.DefaultConf()
+		c.Side, c.NFrames, c.Cycles = 20, 1, cycles
+		return c
+	}
+	a, b := &client{id: "a", daily: 1}, &client{id: "b", daily: 1}
+	for i, step := range []struct {
+		client *client
+		cycles int
+		status int // 0 if rendered or cached
+	}{
+		{a, 1, 0},
+		{a, 1, 0}, // cached
+		{b, 1, 0}, // cached too
+		{a, 2, http.StatusTooManyRequests},
+		{b, 2, 0},
+		{a, 2, 0}, // cached by b
+	} {
+		_, err := cachedRender(step.client, formatPNG, 0, figure(step.cycles))
+		status := 0
+		if err != nil {
+			status = -1
+			if herr, ok := err.(*httpError); ok {
+				status = herr.status
+			}
+		}
+		if status != step.status {
+			t.Errorf("step %d: %s renders cycles=%d: got %v, want status %d",
+				i, step.client.id, step.cycles, err, step.status)
+		}
+	}
+
+	// waiting for the render of a client over its quota does not fail
+	c := &client{id: "c", daily: 1}
+	key := cacheKey(formatPNG, 0, figure(3))
+	started, release := make(chan struct{}), make(chan struct{})
+	go renderFlight.do(key, func() (*cacheEntry, error) {
+		close(started)
+		<-release
+		return nil, quotaError{&httpError{status: http.StatusTooManyRequests}}
+	})
+	<-started
+	done := make(chan error)
+	go func() {
+		_, err := cachedRender(c, formatPNG, 0, figure(3))
+		done <- err
+	}()
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("waiting for a refused render: got %v", err)
+	}
+}
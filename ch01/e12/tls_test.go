@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTLSConfigSelfSigned(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tls")
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	hosts := []string{"example.test", "127.0.0.1"}
+
+	if _, err := tlsConfig("", "", dir, hosts); err != nil {
+		t.Fatal(err)
+	}
+	if !certUsable(certFile, hosts) {
+		t.Fatal("the generated certificate is not usable")
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("key permissions: got %o, want 600", perm)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("got %d files, want only the certificate and the key", len(files))
+	}
+
+	// it is kept while it covers the hosts, and made again when not
+	cert, _ := os.ReadFile(certFile)
+	if _, err := tlsConfig("", "", dir, hosts); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(certFile); !bytes.Equal(again, cert) {
+		t.Error("the certificate was generated again for the same hosts")
+	}
+	more := append(hosts, "other.test")
+	if _, err := tlsConfig("", "", dir, more); err != nil {
+		t.Fatal(err)
+	}
+	if !certUsable(certFile, more) {
+		t.Error("the certificate was not generated again for a new host")
+	}
+}
+
+func TestCertUsable(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.pem")
+	if err := os.WriteFile(bad, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{filepath.Join(dir, "missing.pem"), bad} {
+		if certUsable(file, []string{"localhost"}) {
+			t.Errorf("%s is usable", filepath.Base(file))
+		}
+	}
+}
+
+func TestTLSHosts(t *testing.T) {
+	for _, tc := range []struct {
+		names, addr string
+		want        []string
+	}{
+		{"", ":8443", []string{"localhost", "127.0.0.1", "::1"}},
+		{"", "example.test:8443", []string{"example.test", "localhost", "127.0.0.1", "::1"}},
+		{" a.test, b.test ,", "localhost:8443", []string{"b.test", "a.test", "localhost", "127.0.0.1", "::1"}},
+	} {
+		if got := tlsHosts(tc.names, tc.addr); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("tlsHosts(%q, %q) = %v, want %v", tc.names, tc.addr, got, tc.want)
+		}
+	}
+}